@@ -0,0 +1,10 @@
+// Command mockdns is a mock DNS server meant for use in debugging and testing
+// software/devices interacting with DNS servers. Its records and handlers are
+// in the server package, which tests can import to serve records themselves.
+package main
+
+import "github.com/awoodbeck/mockdns/server"
+
+func main() {
+	server.Main()
+}
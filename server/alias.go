@@ -0,0 +1,87 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// aliasFromMap records that A and AAAA queries for the owner name in m are
+// answered with the addresses of the target name in its value, as provider
+// ALIAS and ANAME records are. Aliases are never sent on the wire.
+func (recs records) aliasFromMap(m map[string]string) error {
+	owner := ownerName(recs.fqdn, m)
+	target := m[keyValue]
+	if target == "" {
+		return fmt.Errorf("%s ALIAS: missing target", owner)
+	}
+	recs.alias[strings.ToLower(owner)] = dns.Fqdn(target)
+
+	return nil
+}
+
+// aliasTarget returns the target of the alias owned by name, if any.
+func (recs records) aliasTarget(name string) (string, bool) {
+	target, ok := recs.alias[strings.ToLower(name)]
+
+	return target, ok
+}
+
+// aliasAddresses returns the target's records of qtype, A or AAAA, renamed to
+// name. Targets in a locally served zone are looked up locally; any others
+// are proxied.
+func (recs records) aliasAddresses(name, target string, qtype uint16) []dns.RR {
+	zones := recs.zones
+	if zones == nil {
+		zones = data{recs.fqdn: recs}
+	}
+
+	var rrs []dns.RR
+	local := false
+	for zone := range zones {
+		local = local || dns.IsSubDomain(zone, target)
+	}
+	if local {
+		for _, rr := range zones.addresses(target) {
+			if rr.Header().Rrtype == qtype {
+				rrs = append(rrs, rr)
+			}
+		}
+	} else if proxy && !localOnly {
+		r := new(dns.Msg)
+		r.SetQuestion(target, qtype)
+		m, _, err := proxyExchange(r)
+		if err != nil {
+			log.Printf("Resolving ALIAS %s to %s: %s", name, target, err)
+		}
+		if m != nil {
+			for _, rr := range m.Answer {
+				if rr.Header().Rrtype == qtype {
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+	}
+
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		out[i] = dns.Copy(rr)
+		out[i].Header().Name = name
+	}
+
+	return out
+}
+
+// checkAliasCNAME returns an error if recs has an ALIAS and a CNAME at the
+// same owner name, which a CNAME can't share (RFC 1034 3.6.2).
+func checkAliasCNAME(recs records) error {
+	for _, rr := range recs.data[dns.TypeCNAME] {
+		if _, ok := recs.aliasTarget(rr.Header().Name); ok {
+			return fmt.Errorf("%s: ALIAS alongside CNAME record", rr.Header().Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestAliasLocalTarget(t *testing.T) {
+	d := loadTestData(t, `{
+		"test.com.": {"alias": [{"hostname": "@", "value": "lb.hosting.test."}]},
+		"hosting.test.": {
+			"a": [{"hostname": "lb", "value": "10.0.0.1", "ttl": "60"}],
+			"aaaa": [{"hostname": "lb", "value": "2001:db8::1", "ttl": "60"}]
+		}
+	}`)
+	s := NewServer(d)
+
+	for qtype, expected := range map[uint16]string{
+		dns.TypeA:    "test.com.\t60\tIN\tA\t10.0.0.1",
+		dns.TypeAAAA: "test.com.\t60\tIN\tAAAA\t2001:db8::1",
+	} {
+		m := query(s.ServeDNS, "test.com.", qtype)
+		if len(m.Answer) != 1 || m.Answer[0].String() != expected {
+			t.Errorf("expected %q; actual: %v", expected, m.Answer)
+		}
+	}
+
+	// Other types at the alias owner are NODATA, not NXDOMAIN.
+	m := query(s.ServeDNS, "test.com.", dns.TypeMX)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Errorf("expected NODATA; actual: %v", m)
+	}
+
+	if name := d["hosting.test."].data[dns.TypeA][0].Header().Name; name != "lb.hosting.test." {
+		t.Fatalf("expected target record unchanged; actual owner: %s", name)
+	}
+}
+
+func TestAliasProxiedTarget(t *testing.T) {
+	stub := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.Question[0].Name == "lb.cdn.example." && r.Question[0].Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, mustRR(t, "lb.cdn.example. 30 IN A 192.0.2.7"))
+		}
+		w.WriteMsg(m)
+	})
+	useUpstreams(t, stub)
+
+	d := loadTestData(t, `{"test.com.": {"aname": [{"hostname": "@", "value": "lb.cdn.example"}]}}`)
+	m := query(handler(d["test.com."]), "test.com.", dns.TypeA)
+	if expected := "test.com.\t30\tIN\tA\t192.0.2.7"; len(m.Answer) != 1 || m.Answer[0].String() != expected {
+		t.Fatalf("expected %q; actual: %v", expected, m.Answer)
+	}
+}
+
+func TestAliasCNAMEConflict(t *testing.T) {
+	defer func(v bool) { strict = v }(strict)
+	strict = true
+
+	d := make(data)
+	err := json.Unmarshal([]byte(`{"test.com.": {
+		"alias": [{"hostname": "www", "value": "lb.hosting.test."}],
+		"cname": [{"hostname": "www", "value": "other.test."}]
+	}}`), &d)
+	if err == nil {
+		t.Fatal("expected ALIAS and CNAME conflict error")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Answer orderings.
+const (
+	orderStable   = "stable"
+	orderRandom   = "random"
+	orderWeighted = "weighted"
+)
+
+// checkOrder returns an error if order isn't a supported answer ordering.
+func checkOrder(order string) error {
+	switch order {
+	case orderStable, orderRandom, orderWeighted:
+		return nil
+	}
+
+	return fmt.Errorf("unknown answer order %q", order)
+}
+
+// orderAnswers returns rrs in the given order: as-is for stable, shuffled
+// for random, or shuffled with higher weighted records more likely to come
+// first for weighted.
+func orderAnswers(rrs []dns.RR, order string, weight func(dns.RR) float64) []dns.RR {
+	if len(rrs) < 2 || order == orderStable {
+		return rrs
+	}
+
+	out := make([]dns.RR, len(rrs))
+	copy(out, rrs)
+
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	switch order {
+	case orderRandom:
+		rng.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	case orderWeighted:
+		// Efraimidis-Spirakis weighted random sampling: sort by u^(1/w).
+		keys := make(map[dns.RR]float64, len(out))
+		for _, rr := range out {
+			keys[rr] = math.Pow(rng.Float64(), 1/weight(rr))
+		}
+		sort.SliceStable(out, func(i, j int) bool { return keys[out[i]] > keys[out[j]] })
+	}
+
+	return out
+}
+
+// reorderAnswers returns rrs with every AAAA record listed before any A
+// record when preferIPv6 is true. All other records keep their positions.
+func reorderAnswers(rrs []dns.RR, preferIPv6 bool) []dns.RR {
+	if !preferIPv6 {
+		return rrs
+	}
+
+	var slots []int
+	var a, aaaa []dns.RR
+	for i, rr := range rrs {
+		switch rr.Header().Rrtype {
+		case dns.TypeA:
+			a = append(a, rr)
+		case dns.TypeAAAA:
+			aaaa = append(aaaa, rr)
+		default:
+			continue
+		}
+		slots = append(slots, i)
+	}
+
+	out := make([]dns.RR, len(rrs))
+	copy(out, rrs)
+	for i, rr := range append(aaaa, a...) {
+		out[slots[i]] = rr
+	}
+
+	return out
+}
+
+// shuffleEqualMX returns rrs with each run of MX records sharing a
+// preference shuffled. All other records keep their positions.
+func shuffleEqualMX(rrs []dns.RR) []dns.RR {
+	out := make([]dns.RR, len(rrs))
+	copy(out, rrs)
+
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	for i := 0; i < len(out); {
+		mx, ok := out[i].(*dns.MX)
+		if !ok {
+			i++
+			continue
+		}
+
+		j := i + 1
+		for ; j < len(out); j++ {
+			next, ok := out[j].(*dns.MX)
+			if !ok || next.Preference != mx.Preference {
+				break
+			}
+		}
+
+		run := out[i:j]
+		rng.Shuffle(len(run), func(a, b int) { run[a], run[b] = run[b], run[a] })
+		i = j
+	}
+
+	return out
+}
+
+// mxGlue returns the locally served A and AAAA records of the exchanges of
+// the MX records in answers, omitting any already in extra.
+func (recs records) mxGlue(answers, extra []dns.RR) []dns.RR {
+	zones := recs.zones
+	if zones == nil {
+		zones = data{recs.fqdn: recs}
+	}
+
+	seen := make(map[dns.RR]bool, len(extra))
+	for _, rr := range extra {
+		seen[rr] = true
+	}
+
+	var glue []dns.RR
+	for _, rr := range answers {
+		mx, ok := rr.(*dns.MX)
+		if !ok {
+			continue
+		}
+		for _, addr := range zones.addresses(mx.Mx) {
+			if !seen[addr] {
+				seen[addr] = true
+				glue = append(glue, addr)
+			}
+		}
+	}
+
+	return glue
+}
+
+// clampTTLs returns rrs with every TTL raised to at least min and, if max is
+// nonzero, lowered to at most max. Records whose TTL changes are copied so
+// the loaded data is left untouched.
+func clampTTLs(rrs []dns.RR, min, max time.Duration) []dns.RR {
+	lo, hi := uint32(min/time.Second), uint32(max/time.Second)
+
+	var out []dns.RR
+	for i, rr := range rrs {
+		ttl := rr.Header().Ttl
+		switch {
+		case ttl < lo:
+			ttl = lo
+		case hi > 0 && ttl > hi:
+			ttl = hi
+		default:
+			continue
+		}
+
+		if out == nil {
+			out = make([]dns.RR, len(rrs))
+			copy(out, rrs)
+		}
+		out[i] = dns.Copy(rr)
+		out[i].Header().Ttl = ttl
+	}
+
+	if out == nil {
+		return rrs
+	}
+
+	return out
+}
+
+// limitAnswers returns rrs with at most max records of each type, keeping the
+// first of each. Combined with random ordering, that's a random max of each.
+func limitAnswers(rrs []dns.RR, max int) []dns.RR {
+	if max <= 0 || len(rrs) <= max {
+		return rrs
+	}
+
+	counts := make(map[uint16]int)
+	out := make([]dns.RR, 0, len(rrs))
+	for _, rr := range rrs {
+		typ := rr.Header().Rrtype
+		if counts[typ] < max {
+			counts[typ]++
+			out = append(out, rr)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,338 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rr
+}
+
+func rrTypes(rrs []dns.RR) []string {
+	types := make([]string, len(rrs))
+	for i, rr := range rrs {
+		types[i] = dns.TypeToString[rr.Header().Rrtype]
+	}
+
+	return types
+}
+
+func TestReorderAnswers(t *testing.T) {
+	t.Parallel()
+
+	rrs := []dns.RR{
+		mustRR(t, "test.com. 3600 IN A 10.0.0.1"),
+		mustRR(t, "test.com. 3600 IN MX 10 mail.test.com."),
+		mustRR(t, "test.com. 3600 IN A 10.0.0.2"),
+		mustRR(t, "test.com. 3600 IN AAAA fd00::1"),
+	}
+
+	for _, c := range []struct {
+		preferIPv6 bool
+		expected   []string
+	}{
+		{false, []string{"A", "MX", "A", "AAAA"}},
+		{true, []string{"AAAA", "MX", "A", "A"}},
+	} {
+		actual := rrTypes(reorderAnswers(rrs, c.preferIPv6))
+		for i := range c.expected {
+			if actual[i] != c.expected[i] {
+				t.Errorf("preferIPv6=%t: expected %v; actual: %v", c.preferIPv6, c.expected, actual)
+				break
+			}
+		}
+	}
+}
+
+func TestMXSortedByPreference(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {"mx": [
+		{"hostname": "@", "priority": "30", "value": "mx3.test.com."},
+		{"hostname": "@", "priority": "10", "value": "mx1.test.com."},
+		{"hostname": "@", "priority": "20", "value": "mx2.test.com."},
+		{"hostname": "@", "priority": "10", "value": "mx1b.test.com."}
+	]}}`)
+
+	m := query(handler(d["test.com."]), "test.com.", dns.TypeMX)
+	expected := []string{"mx1.test.com.", "mx1b.test.com.", "mx2.test.com.", "mx3.test.com."}
+	if len(m.Answer) != len(expected) {
+		t.Fatalf("expected %d answers; actual: %v", len(expected), m.Answer)
+	}
+	for i, rr := range m.Answer {
+		if mx := rr.(*dns.MX).Mx; mx != expected[i] {
+			t.Errorf("answer %d: expected %s; actual: %s", i, expected[i], mx)
+		}
+	}
+}
+
+func TestShuffleEqualMX(t *testing.T) {
+	rrs := []dns.RR{
+		mustRR(t, "test.com. 3600 IN MX 10 mx1.test.com."),
+		mustRR(t, "test.com. 3600 IN MX 10 mx2.test.com."),
+		mustRR(t, "test.com. 3600 IN MX 10 mx3.test.com."),
+		mustRR(t, "test.com. 3600 IN MX 20 mx4.test.com."),
+	}
+
+	seedRNG(1)
+	firsts := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		out := shuffleEqualMX(rrs)
+		if mx := out[3].(*dns.MX).Mx; mx != "mx4.test.com." {
+			t.Fatalf("expected lower priority MX last; actual: %s", mx)
+		}
+		for _, rr := range out[:3] {
+			if rr.(*dns.MX).Preference != 10 {
+				t.Fatalf("expected preference 10 MX records first; actual: %v", out)
+			}
+		}
+		firsts[out[0].(*dns.MX).Mx] = true
+	}
+	if len(firsts) < 2 {
+		t.Fatalf("expected equal preference MX records shuffled; actual firsts: %v", firsts)
+	}
+
+	if mx := rrs[0].(*dns.MX).Mx; mx != "mx1.test.com." {
+		t.Fatalf("expected input unchanged; actual first: %s", mx)
+	}
+}
+
+func TestOrderAnswers(t *testing.T) {
+	rrs := []dns.RR{
+		mustRR(t, "test.com. 3600 IN A 10.0.0.1"),
+		mustRR(t, "test.com. 3600 IN A 10.0.0.2"),
+		mustRR(t, "test.com. 3600 IN A 10.0.0.3"),
+		mustRR(t, "test.com. 3600 IN A 10.0.0.4"),
+	}
+	first := func(rrs []dns.RR) string { return rrs[0].(*dns.A).A.String() }
+	unweighted := func(dns.RR) float64 { return 1 }
+
+	t.Run("stable", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			out := orderAnswers(rrs, orderStable, unweighted)
+			for j := range rrs {
+				if out[j] != rrs[j] {
+					t.Fatalf("expected file order; actual: %v", out)
+				}
+			}
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		run := func(seed int64) []string {
+			seedRNG(seed)
+			firsts := make([]string, 20)
+			for i := range firsts {
+				firsts[i] = first(orderAnswers(rrs, orderRandom, unweighted))
+			}
+			return firsts
+		}
+
+		a, b, c := run(1), run(1), run(2)
+		var varies, differs bool
+		for i := range a {
+			if a[i] != b[i] {
+				t.Fatalf("expected same order for the same seed; actual: %v and %v", a, b)
+			}
+			varies = varies || a[i] != a[0]
+			differs = differs || a[i] != c[i]
+		}
+		if !varies {
+			t.Fatalf("expected random order to vary between queries; actual: %v", a)
+		}
+		if !differs {
+			t.Fatalf("expected random order to vary with seed; actual: %v", a)
+		}
+	})
+
+	t.Run("weighted", func(t *testing.T) {
+		heavy := rrs[3]
+		weight := func(rr dns.RR) float64 {
+			if rr == heavy {
+				return 9
+			}
+			return 1
+		}
+
+		seedRNG(42)
+		const n = 1000
+		var firsts int
+		for i := 0; i < n; i++ {
+			if orderAnswers(rrs, orderWeighted, weight)[0] == heavy {
+				firsts++
+			}
+		}
+
+		// The heavy record leads with probability 9/12.
+		if firsts < n*65/100 || firsts > n*85/100 {
+			t.Fatalf("expected heavy record first roughly %d times; actual: %d", n*3/4, firsts)
+		}
+	})
+}
+
+func TestRecordWeight(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1", "weight": "5"},
+		{"hostname": "@", "value": "10.0.0.2"}
+	]}}`)
+
+	recs := d["test.com."]
+	for i, expected := range []float64{5, 1} {
+		if actual := recs.weight(recs.data[dns.TypeA][i]); actual != expected {
+			t.Errorf("record %d: expected weight %g; actual: %g", i, expected, actual)
+		}
+	}
+
+	err := json.Unmarshal([]byte(`{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1", "weight": "0"}
+	]}}`), &d)
+	if err == nil {
+		t.Fatal("expected invalid weight error")
+	}
+}
+
+func TestMXGlue(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{
+		"test.com.": {"mx": [
+			{"hostname": "@", "priority": "10", "value": "mail.test.net."},
+			{"hostname": "@", "priority": "20", "value": "mail.elsewhere.test."}
+		]},
+		"test.net.": {"a": [{"hostname": "mail", "value": "10.0.0.25"}]}
+	}`)
+	s := NewServer(d)
+
+	m := query(s.ServeDNS, "test.com.", dns.TypeMX)
+	if len(m.Answer) != 2 {
+		t.Fatalf("expected 2 MX answers; actual: %v", m.Answer)
+	}
+	if len(m.Extra) != 1 {
+		t.Fatalf("expected exchange A record in additional; actual: %v", m.Extra)
+	}
+	if a, ok := m.Extra[0].(*dns.A); !ok || a.Hdr.Name != "mail.test.net." || a.A.String() != "10.0.0.25" {
+		t.Fatalf("expected mail.test.net. A 10.0.0.25; actual: %v", m.Extra[0])
+	}
+}
+
+func TestSectionTTLs(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "ns1", "value": "10.0.0.53", "ttl": "3600", "ttl_additional": "60"}],
+		"ns": [{"hostname": "@", "value": "ns1.test.com.", "ttl": "1h", "ttl_authority": "5m"}]
+	}}`)
+	s := NewServer(d)
+
+	m := query(s.ServeDNS, "ns1.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Ttl != 3600 {
+		t.Fatalf("expected answer TTL 3600; actual: %v", m.Answer)
+	}
+	if len(m.Extra) != 1 || m.Extra[0].Header().Ttl != 60 {
+		t.Fatalf("expected additional TTL 60; actual: %v", m.Extra)
+	}
+	if len(m.Ns) != 1 || m.Ns[0].Header().Ttl != 300 {
+		t.Fatalf("expected authority TTL 300; actual: %v", m.Ns)
+	}
+
+	// The stored records keep their TTLs.
+	if ttl := d["test.com."].data[dns.TypeA][0].Header().Ttl; ttl != 3600 {
+		t.Fatalf("expected stored TTL 3600; actual: %d", ttl)
+	}
+}
+
+func TestClampTTLs(t *testing.T) {
+	t.Parallel()
+
+	rrs := []dns.RR{
+		mustRR(t, "a.test.com. 0 IN A 10.0.0.1"),
+		mustRR(t, "b.test.com. 59 IN A 10.0.0.2"),
+		mustRR(t, "c.test.com. 60 IN A 10.0.0.3"),
+		mustRR(t, "d.test.com. 300 IN A 10.0.0.4"),
+		mustRR(t, "e.test.com. 301 IN A 10.0.0.5"),
+	}
+
+	for _, c := range []struct {
+		min, max time.Duration
+		expected []uint32
+	}{
+		{0, 0, []uint32{0, 59, 60, 300, 301}},
+		{time.Minute, 0, []uint32{60, 60, 60, 300, 301}},
+		{0, 5 * time.Minute, []uint32{0, 59, 60, 300, 300}},
+		{time.Minute, 5 * time.Minute, []uint32{60, 60, 60, 300, 300}},
+	} {
+		out := clampTTLs(rrs, c.min, c.max)
+		for i, rr := range out {
+			if ttl := rr.Header().Ttl; ttl != c.expected[i] {
+				t.Errorf("min=%s max=%s: expected %s TTL %d; actual: %d",
+					c.min, c.max, rr.Header().Name, c.expected[i], ttl)
+			}
+		}
+	}
+
+	if ttl := rrs[0].Header().Ttl; ttl != 0 {
+		t.Fatalf("expected original TTL 0; actual: %d", ttl)
+	}
+}
+
+func TestHandlerMinTTL(t *testing.T) {
+	defer func(v time.Duration) { minTTL = v }(minTTL)
+	minTTL = time.Minute
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1", "ttl": "0"}]}}`)
+	m := query(handler(d["test.com."]), "www.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Ttl != 60 {
+		t.Fatalf("expected answer TTL 60; actual: %v", m.Answer)
+	}
+	if ttl := d["test.com."].data[dns.TypeA][0].Header().Ttl; ttl != 0 {
+		t.Fatalf("expected stored TTL 0; actual: %d", ttl)
+	}
+}
+
+func TestHandlerMaxAnswers(t *testing.T) {
+	defer func(n int, o string) { maxAnswers, answerOrder = n, o }(maxAnswers, answerOrder)
+	maxAnswers = 2
+
+	d := loadTestData(t, `{"test.com.": {"a": [
+		{"hostname": "www", "value": "10.0.0.1"},
+		{"hostname": "www", "value": "10.0.0.2"},
+		{"hostname": "www", "value": "10.0.0.3"},
+		{"hostname": "www", "value": "10.0.0.4"},
+		{"hostname": "www", "value": "10.0.0.5"}
+	]}}`)
+	h := handler(d["test.com."])
+
+	answerOrder = orderStable
+	m := query(h, "www.test.com.", dns.TypeA)
+	if len(m.Answer) != 2 || m.Answer[0].(*dns.A).A.String() != "10.0.0.1" || m.Answer[1].(*dns.A).A.String() != "10.0.0.2" {
+		t.Fatalf("expected the first 2 answers; actual: %v", m.Answer)
+	}
+
+	answerOrder = orderRandom
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		m = query(h, "www.test.com.", dns.TypeA)
+		if len(m.Answer) != 2 {
+			t.Fatalf("expected 2 answers; actual: %v", m.Answer)
+		}
+		for _, rr := range m.Answer {
+			seen[rr.(*dns.A).A.String()] = true
+		}
+	}
+	if len(seen) < 3 {
+		t.Fatalf("expected random answers from all 5 records; saw: %v", seen)
+	}
+}
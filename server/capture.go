@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Capture file formats.
+const (
+	captureQlog   = "qlog"
+	capturePcapng = "pcapng"
+)
+
+// queryCapture writes every incoming query to a capture file.
+type queryCapture struct {
+	mu     sync.Mutex
+	f      *os.File
+	format string
+	enc    *json.Encoder
+}
+
+// qlogEvent is a single query_received event in a qlog capture file.
+type qlogEvent struct {
+	Time string   `json:"time"`
+	Name string   `json:"name"`
+	Data qlogData `json:"data"`
+}
+
+type qlogData struct {
+	Client  string `json:"client"`
+	Handler string `json:"handler"`
+	Raw     []byte `json:"raw"`
+}
+
+// newQueryCapture creates the capture file at path in the given format,
+// qlog or pcapng.
+func newQueryCapture(path, format string) (*queryCapture, error) {
+	if format != captureQlog && format != capturePcapng {
+		return nil, fmt.Errorf("unknown capture format %q", format)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &queryCapture{f: f, format: format, enc: json.NewEncoder(f)}
+	if format == capturePcapng {
+		_, err = f.Write(pcapngHeader())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Capture records the query r received through w and handled by the named
+// handler type, as it arrived on the wire if it was read off a socket.
+func (c *queryCapture) Capture(w dns.ResponseWriter, handler string, r *dns.Msg) error {
+	raw := receivedQueries.wire(w.RemoteAddr())
+	if raw == nil {
+		var err error
+		raw, err = r.Pack()
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+
+	if c.format == capturePcapng {
+		packet, err := udpPacket(w.RemoteAddr(), w.LocalAddr(), raw)
+		if err != nil {
+			return err
+		}
+		var network string
+		if w.RemoteAddr() != nil {
+			network = w.RemoteAddr().Network()
+		}
+		comment := fmt.Sprintf("handler: %s, transport: %s", handler, network)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		_, err = c.f.Write(pcapngPacket(now, packet, comment))
+		return err
+	}
+
+	e := qlogEvent{
+		Time: now.UTC().Format(time.RFC3339Nano),
+		Name: "dns:query_received",
+		Data: qlogData{
+			Handler: handler,
+			Raw:     raw,
+		},
+	}
+	if client := w.RemoteAddr(); client != nil {
+		e.Data.Client = client.String()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.enc.Encode(e)
+}
+
+// Close flushes and closes the capture file.
+func (c *queryCapture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.f.Sync()
+	if cErr := c.f.Close(); err == nil {
+		err = cErr
+	}
+
+	return err
+}
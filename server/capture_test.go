@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryCaptureQlog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.qlog")
+	c, err := newQueryCapture(path, "qlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(qc *queryCapture) { capture = qc }(capture)
+	capture = c
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	query(logRequest(true, handler(d["test.com."])), "test.com.", dns.TypeA)
+
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e qlogEvent
+	err = json.Unmarshal(b, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Data.Handler != "local" {
+		t.Errorf("expected local handler; actual: %q", e.Data.Handler)
+	}
+	if e.Data.Client == "" {
+		t.Error("expected client address")
+	}
+
+	r := new(dns.Msg)
+	err = r.Unpack(e.Data.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Question) != 1 || r.Question[0].Name != "test.com." {
+		t.Fatalf("expected captured test.com. question; actual: %v", r.Question)
+	}
+}
+
+func TestQueryCaptureUnknownFormat(t *testing.T) {
+	t.Parallel()
+
+	_, err := newQueryCapture(filepath.Join(os.TempDir(), "unused"), "bogus")
+	if err == nil {
+		t.Fatal("expected unknown format error")
+	}
+}
+
+func TestQueryCapturePcapng(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "capture.pcapng")
+	c, err := newQueryCapture(path, "pcapng")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(qc *queryCapture) { capture = qc }(capture)
+	capture = c
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts, _ := NewTestServer(t, d)
+
+	// A compressed name only survives in the capture if it records the
+	// query as received rather than repacking it.
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	r.Extra = append(r.Extra, &dns.A{
+		Hdr: dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeA, Class: dns.ClassINET},
+		A:   []byte{10, 0, 0, 2},
+	})
+	r.Compress = true
+	sent, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, err = conn.Write(sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, dns.MinMsgSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets [][]byte
+	for len(b) >= 12 {
+		typ, n := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+		if typ == pcapngEnhancedPacket {
+			captured := binary.LittleEndian.Uint32(b[20:])
+			packets = append(packets, b[28:28+captured])
+		}
+		b = b[n:]
+	}
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 captured packet; actual: %d", len(packets))
+	}
+
+	ip := packets[0]
+	if ip[0] != 0x45 || checksum(ip[:20], 0) != 0 {
+		t.Fatalf("expected a valid IPv4 header; actual: % x", ip[:20])
+	}
+	client := conn.LocalAddr().(*net.UDPAddr)
+	if port := int(binary.BigEndian.Uint16(ip[20:])); port != client.Port {
+		t.Errorf("expected client port %d; actual: %d", client.Port, port)
+	}
+	if payload := ip[28:]; !bytes.Equal(payload, sent) {
+		t.Fatalf("expected the query as sent\n% x\nactual:\n% x", sent, payload)
+	}
+}
+
+func TestUDPPacketIPv6(t *testing.T) {
+	t.Parallel()
+
+	src := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}
+	dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::53"), Port: 53}
+	p, err := udpPacket(src, dst, []byte("query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p[0]>>4 != 6 || len(p) != 40+8+5 {
+		t.Fatalf("expected an IPv6 packet; actual: % x", p)
+	}
+
+	// The checksum over the pseudo-header and datagram, checksum included,
+	// is zero.
+	udp := p[40:]
+	if sum := checksum(udp, uint32(^checksum(p[8:40], uint32(len(udp))+17))); sum != 0 {
+		t.Fatalf("expected a valid UDP checksum; actual: %#04x", sum)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// certFields returns the type, key tag and algorithm fields preceding the
+// certificate in CERT record data (RFC 4398) from the cert_type, key_tag and
+// algorithm fields of m. The algorithm defaults to 0 (none).
+func certFields(m map[string]string) (string, error) {
+	typ := strings.ToUpper(m[keyCertType])
+	if _, ok := dns.StringToCertType[typ]; !ok {
+		if _, err := strconv.ParseUint(typ, 10, 16); err != nil {
+			return "", fmt.Errorf("unknown cert_type %q", m[keyCertType])
+		}
+	}
+
+	tag, ok := m[keyKeyTag]
+	if !ok {
+		tag = "0"
+	}
+	if _, err := strconv.ParseUint(tag, 10, 16); err != nil {
+		return "", fmt.Errorf("invalid key_tag %q", tag)
+	}
+
+	alg, ok := m[keyAlgorithm]
+	if !ok {
+		alg = "0"
+	}
+	alg = strings.ToUpper(alg)
+	if _, ok := dns.StringToAlgorithm[alg]; !ok {
+		if _, err := strconv.ParseUint(alg, 10, 8); err != nil {
+			return "", fmt.Errorf("unknown algorithm %q", m[keyAlgorithm])
+		}
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(m[keyValue]); err != nil {
+		return "", fmt.Errorf("invalid base64 certificate: %s", err)
+	}
+
+	return strings.Join([]string{typ, tag, alg}, " "), nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/miekg/dns"
+)
+
+// registerChaosHandlers adds handlers answering CHAOS-class TXT queries for
+// version.bind. and id.server. to mux. Queries for those names in any other
+// class are passed to the proxy handler.
+func registerChaosHandlers(mux *dns.ServeMux) {
+	for name, txt := range map[string]string{
+		"version.bind.": versionString,
+		"id.server.":    serverID,
+	} {
+		mux.HandleFunc(name, chaosHandler(
+			logRequest(true, chaosTXTHandler(txt)),
+			logRequest(false, proxyHandler),
+		))
+	}
+}
+
+// chaosHandler dispatches CHAOS-class queries to ch and all others to next.
+func chaosHandler(ch, next func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if len(r.Question) == 0 || r.Question[0].Qclass != dns.ClassCHAOS {
+			next(w, r)
+			return
+		}
+		ch(w, r)
+	}
+}
+
+// chaosTXTHandler answers TXT (and ANY) questions with a CH-class TXT record
+// containing txt.
+func chaosTXTHandler(txt string) func(dns.ResponseWriter, *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+
+		for _, q := range r.Question {
+			if q.Qtype != dns.TypeTXT && q.Qtype != dns.TypeANY {
+				continue
+			}
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{
+					Name:   q.Name,
+					Rrtype: dns.TypeTXT,
+					Class:  dns.ClassCHAOS,
+				},
+				Txt: []string{txt},
+			})
+		}
+
+		writeMsg(w, r, m)
+	}
+}
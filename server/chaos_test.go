@@ -0,0 +1,59 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestChaosQueries(t *testing.T) {
+	defer func(c bool, v, id string) {
+		chaos, versionString, serverID = c, v, id
+	}(chaos, versionString, serverID)
+	chaos, versionString, serverID = true, "mockdns-test", "instance-1"
+
+	defer func(v bool) { localOnly = v }(localOnly)
+	localOnly = true
+
+	mux := dns.NewServeMux()
+	registerHandlers(mux, make(data))
+
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"version.bind.", versionString},
+		{"id.server.", serverID},
+	} {
+		r := new(dns.Msg)
+		r.SetQuestion(c.name, dns.TypeTXT)
+		r.Question[0].Qclass = dns.ClassCHAOS
+
+		w := new(testResponseWriter)
+		mux.ServeDNS(w, r)
+
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("%s: expected 1 answer; actual: %v", c.name, w.msg.Answer)
+		}
+		txt, ok := w.msg.Answer[0].(*dns.TXT)
+		if !ok {
+			t.Fatalf("%s: expected *dns.TXT; actual: %T", c.name, w.msg.Answer[0])
+		}
+		if txt.Hdr.Class != dns.ClassCHAOS {
+			t.Errorf("%s: expected CH class; actual: %s", c.name, dns.ClassToString[txt.Hdr.Class])
+		}
+		if len(txt.Txt) != 1 || txt.Txt[0] != c.expected {
+			t.Errorf("%s: expected %q; actual: %v", c.name, c.expected, txt.Txt)
+		}
+
+		// The same name in the IN class must not match the CHAOS handler.
+		r.Question[0].Qclass = dns.ClassINET
+		w = new(testResponseWriter)
+		mux.ServeDNS(w, r)
+
+		if w.msg.Rcode != dns.RcodeRefused || len(w.msg.Answer) != 0 {
+			t.Errorf("%s IN: expected unmatched REFUSED; actual: %s %v", c.name,
+				dns.RcodeToString[w.msg.Rcode], w.msg.Answer)
+		}
+	}
+}
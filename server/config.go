@@ -0,0 +1,316 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// effectiveConfig is the fully-resolved configuration printed by -print-config.
+type effectiveConfig struct {
+	Addr                   string   `json:"addr"`
+	MetricsAddr            string   `json:"metrics_addr"`
+	ProfileAddr            string   `json:"profile_addr"`
+	MaxConnections         int      `json:"max_connections"`
+	MaxPayload             int      `json:"max_payload"`
+	MaxConnectionWait      string   `json:"max_connection_wait"`
+	MinTTL                 string   `json:"min_ttl"`
+	MaxTTL                 string   `json:"max_ttl"`
+	DataFile               string   `json:"data"`
+	DefaultTTL             string   `json:"ttl"`
+	Proxy                  bool     `json:"proxy"`
+	ServeLocalOnly         bool     `json:"serve_local_only"`
+	ProxyDoH               string   `json:"proxy_upstream_doh"`
+	ProxyTimeout           string   `json:"proxy_timeout"`
+	ProxyServerTimeout     string   `json:"proxy_server_timeout"`
+	ProxyTotalTimeout      string   `json:"proxy_total_timeout"`
+	ProxyRetryInitialDelay string   `json:"proxy_retry_initial_delay"`
+	QueryTimeout           string   `json:"query_timeout"`
+	ProxyRetryMaxDelay     string   `json:"proxy_retry_max_delay"`
+	UpstreamHealthInterval string   `json:"upstream_health_interval"`
+	ProxyConcurrency       int      `json:"proxy_concurrency"`
+	ServeStale             bool     `json:"serve_stale"`
+	StripDNSSEC            bool     `json:"strip_dnssec"`
+	ResolvConfFile         string   `json:"resolv"`
+	Upstreams              []string `json:"upstreams"`
+	LogFormat              string   `json:"log_format"`
+	LogFile                string   `json:"log_file"`
+	LogRotateSignal        string   `json:"query_log_rotate_signal"`
+	DoTAddr                string   `json:"tls_addr"`
+	DoHAddr                string   `json:"doh_addr"`
+	RequestIDHeader        string   `json:"request_id_header"`
+	TLSCert                string   `json:"tls_cert"`
+	TLSKey                 string   `json:"tls_key"`
+	TLSClientCA            string   `json:"tls_client_ca"`
+	TLSClientCAOptional    bool     `json:"tls_client_ca_optional"`
+	OTLPEndpoint           string   `json:"otlp_endpoint"`
+	CaptureFile            string   `json:"query_capture_file"`
+	AnswerFromFile         string   `json:"answer_from_file"`
+	CaptureFormat          string   `json:"query_capture_format"`
+	AAAAServfail           bool     `json:"aaaa_servfail"`
+	CoalesceLocal          bool     `json:"coalesce_local"`
+	PreferIPv6             bool     `json:"prefer_ipv6"`
+	PreserveCase           bool     `json:"preserve_case"`
+	AnswerOrder            string   `json:"order"`
+	MaxAnswers             int      `json:"max_answers"`
+	UpstreamStrategy       string   `json:"upstream_retry_strategy"`
+	ProxyOrder             string   `json:"proxy_order"`
+	MXRandomizeEqual       bool     `json:"mx_randomize_equal"`
+	RefuseMultiQ           bool     `json:"refuse_multi_question"`
+	Chaos                  bool     `json:"chaos"`
+	VersionString          string   `json:"version_string"`
+	ServerID               string   `json:"server_id"`
+	NSID                   string   `json:"nsid"`
+	DebugExtra             bool     `json:"debug_extra"`
+	Strict                 bool     `json:"strict"`
+	NoFatalParse           bool     `json:"no_fatal_parse"`
+	NoProxyOnError         bool     `json:"no_proxy_on_error"`
+	PadTo                  int      `json:"pad_to"`
+	StartupDelay           string   `json:"startup_delay"`
+	MinLatency             string   `json:"min_latency"`
+	DelayDist              string   `json:"response_delay_distribution"`
+	DelayParams            struct {
+		Mean   string `json:"mean"`
+		StdDev string `json:"stddev"`
+		Min    string `json:"min"`
+		Max    string `json:"max"`
+	} `json:"delay"`
+	AnswerDelays    string `json:"answer_delay_per_type"`
+	Views           string `json:"views"`
+	SourcePortViews string `json:"source_port_map"`
+	Rewrites        string `json:"rewrite"`
+	TypeConfuse     string `json:"type_confuse"`
+	CookieSecret    bool   `json:"cookie_secret_set"`
+	CookieEnforce   bool   `json:"cookie_enforce"`
+	ZoneMD          bool   `json:"zonemd"`
+	ZoneSerial      string `json:"zone_serial"`
+	StateFile       string `json:"state_file"`
+	Seed            int64  `json:"seed"`
+	Verbose         bool   `json:"verbose"`
+	Domains         int    `json:"domains"`
+}
+
+func newEffectiveConfig(d data) effectiveConfig {
+	cfg := effectiveConfig{
+		Addr:                   addr,
+		MetricsAddr:            metricsAddr,
+		ProfileAddr:            profileAddr,
+		MaxPayload:             maxPayload,
+		MaxConnections:         maxConnections,
+		MaxConnectionWait:      maxConnectionWait.String(),
+		MinTTL:                 minTTL.String(),
+		MaxTTL:                 maxTTL.String(),
+		DataFile:               dataFile,
+		DefaultTTL:             defaultTTL,
+		Proxy:                  proxy,
+		ServeLocalOnly:         localOnly,
+		ProxyDoH:               proxyDoH,
+		ProxyTimeout:           proxyTimeout.String(),
+		ProxyServerTimeout:     proxyServerTimeout.String(),
+		ProxyTotalTimeout:      proxyTotalTimeout.String(),
+		QueryTimeout:           queryTimeout.String(),
+		ProxyRetryInitialDelay: proxyRetryInitialDelay.String(),
+		ProxyRetryMaxDelay:     proxyRetryMaxDelay.String(),
+		UpstreamHealthInterval: upstreamHealthInterval.String(),
+		ServeStale:             serveStale,
+		StripDNSSEC:            stripDNSSECRRs,
+		ProxyConcurrency:       proxyConcurrency,
+		ResolvConfFile:         resolvConfFile,
+		Upstreams:              []string{},
+		LogFormat:              logFormat,
+		LogFile:                logFilePath,
+		LogRotateSignal:        logRotateSignal,
+		DoTAddr:                dotAddr,
+		DoHAddr:                dohAddr,
+		RequestIDHeader:        requestIDHeader,
+		TLSCert:                tlsCert,
+		TLSKey:                 tlsKey,
+		TLSClientCA:            tlsClientCA,
+		TLSClientCAOptional:    tlsClientCAOptional,
+		OTLPEndpoint:           otlpEndpoint,
+		CaptureFile:            captureFile,
+		AnswerFromFile:         answerFromFile,
+		CaptureFormat:          captureFormat,
+		AAAAServfail:           aaaaServfail,
+		CoalesceLocal:          coalesceLocal,
+		PreferIPv6:             preferIPv6,
+		PreserveCase:           preserveCase,
+		AnswerOrder:            answerOrder,
+		MaxAnswers:             maxAnswers,
+		UpstreamStrategy:       upstreamStrategy,
+		ProxyOrder:             proxyOrder,
+		MXRandomizeEqual:       mxRandomizeEqual,
+		RefuseMultiQ:           refuseMultiQ,
+		Chaos:                  chaos,
+		VersionString:          versionString,
+		ServerID:               serverID,
+		DebugExtra:             debugExtra,
+		NSID:                   nsid,
+		NoFatalParse:           noFatalParse,
+		NoProxyOnError:         noProxyOnError,
+		Strict:                 strict,
+		PadTo:                  padTo,
+		MinLatency:             minLatency.String(),
+		StartupDelay:           startupDelay.String(),
+		DelayDist:              delayDist,
+		AnswerDelays:           answerDelays.String(),
+		Views:                  views.String(),
+		SourcePortViews:        sourcePortViews.String(),
+		Rewrites:               rewrites.String(),
+		TypeConfuse:            typeConfuse.String(),
+		CookieSecret:           cookieSecret != "",
+		CookieEnforce:          cookieEnforce,
+		ZoneMD:                 zonemd,
+		ZoneSerial:             zoneSerial,
+		StateFile:              stateFile,
+		Seed:                   seed,
+		Verbose:                verbose,
+		Domains:                len(d),
+	}
+
+	cfg.DelayParams.Mean = delayParams.Mean.String()
+	cfg.DelayParams.StdDev = delayParams.StdDev.String()
+	cfg.DelayParams.Min = delayParams.Min.String()
+	cfg.DelayParams.Max = delayParams.Max.String()
+
+	if clientConfig != nil {
+		for _, ns := range clientConfig.Servers {
+			cfg.Upstreams = append(cfg.Upstreams, fmt.Sprintf("%s:%s", ns, clientConfig.Port))
+		}
+	}
+
+	return cfg
+}
+
+// printConfig writes the effective configuration to w as indented JSON.
+func printConfig(w io.Writer, d data) error {
+	b, err := json.MarshalIndent(newEffectiveConfig(d), "", "    ")
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(w, string(b))
+
+	return err
+}
+
+// repeatable is implemented by flag values set by repeated arguments, which
+// configuration files list as arrays.
+type repeatable interface {
+	flag.Value
+
+	// values returns the arguments that set the value, one per repetition.
+	values() []string
+}
+
+// loadConfigFile sets the flags in fs from the TOML file at path. Keys are
+// flag names and only top-level keys are supported; repeatable flags take an
+// array of values. Flags already set on the command line take precedence over
+// the file.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	var settings map[string]interface{}
+	md, err := toml.DecodeFile(path, &settings)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for _, key := range md.Keys() {
+		if len(key) > 1 {
+			continue // reported with its table below
+		}
+
+		name := key[0]
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+
+		args, aErr := configArgs(settings[name])
+		if aErr != nil {
+			return fmt.Errorf("%s: %s: %s", path, name, aErr)
+		}
+		for _, arg := range args {
+			err = fs.Set(name, arg)
+			if err != nil {
+				return fmt.Errorf("%s: %s: %s", path, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// configArgs returns the flag arguments for a configuration file value: one
+// for a string, integer, float or boolean, or one per element of an array of
+// them.
+func configArgs(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case int64:
+		return []string{strconv.FormatInt(v, 10)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'g', -1, 64)}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case []interface{}:
+		args := make([]string, 0, len(v))
+		for _, e := range v {
+			if _, ok := e.([]interface{}); ok {
+				return nil, errors.New("nested arrays are not supported")
+			}
+			arg, err := configArgs(e)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg...)
+		}
+		return args, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// dumpConfig writes every flag in fs to w as a TOML file loadable by
+// -config. Unset flags without a usable zero value are omitted.
+func dumpConfig(w io.Writer, fs *flag.FlagSet) error {
+	settings := make(map[string]interface{})
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+
+		if r, ok := f.Value.(repeatable); ok {
+			if v := r.values(); len(v) > 0 {
+				settings[f.Name] = v
+			}
+			return
+		}
+
+		g, ok := f.Value.(flag.Getter)
+		if !ok {
+			if v := f.Value.String(); v != "" {
+				settings[f.Name] = v
+			}
+			return
+		}
+		switch v := g.Get().(type) {
+		case bool, int, int64, uint, uint64, float64:
+			settings[f.Name] = v
+		default:
+			settings[f.Name] = f.Value.String()
+		}
+	})
+
+	return toml.NewEncoder(w).Encode(settings)
+}
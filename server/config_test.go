@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestPrintConfig(t *testing.T) {
+	defer func(c *dns.ClientConfig) { clientConfig = c }(clientConfig)
+	clientConfig = &dns.ClientConfig{
+		Servers: []string{"192.0.2.1", "192.0.2.2"},
+		Port:    "53",
+	}
+
+	var buf bytes.Buffer
+	err := printConfig(&buf, make(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ns := range []string{`"192.0.2.1:53"`, `"192.0.2.2:53"`} {
+		if !strings.Contains(buf.String(), ns) {
+			t.Errorf("expected output to contain %s; actual: %s", ns, buf.String())
+		}
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(`# mockdns settings
+addr = "127.0.0.1:5353" # listen here
+ttl = '60'
+proxy = false
+max-connections = 10
+`)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		a, ttl string
+		p      bool
+		n      int
+	)
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&a, "addr", "", "")
+	fs.StringVar(&ttl, "ttl", "3600", "")
+	fs.BoolVar(&p, "proxy", true, "")
+	fs.IntVar(&n, "max-connections", 0, "")
+
+	err = fs.Parse([]string{"-ttl", "120"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loadConfigFile(fs, f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a != "127.0.0.1:5353" || p || n != 10 {
+		t.Fatalf("expected config file values; actual: addr=%q proxy=%t max-connections=%d", a, p, n)
+	}
+	if ttl != "120" {
+		t.Fatalf("expected command line ttl to take precedence; actual: %q", ttl)
+	}
+
+	var buf bytes.Buffer
+	err = dumpConfig(&buf, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "addr = \"127.0.0.1:5353\"\nmax-connections = 10\nproxy = false\nttl = \"120\"\n"
+	if buf.String() != expected {
+		t.Fatalf("expected dump %q; actual: %q", expected, buf.String())
+	}
+}
+
+func TestLoadConfigFileUnknownSetting(t *testing.T) {
+	t.Parallel()
+
+	f, err := ioutil.TempFile("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString("bogus = true\n")
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = loadConfigFile(flag.NewFlagSet("test", flag.ContinueOnError), f.Name())
+	if err == nil {
+		t.Fatal("expected unknown setting error")
+	}
+}
+
+func TestDumpConfigRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	newFlags := func() (*flag.FlagSet, []flag.Value) {
+		values := []flag.Value{
+			make(typeDelays),
+			make(viewFiles),
+			make(portViews),
+			new(rewriteRules),
+			make(typeConfusions),
+		}
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("addr", "", "")
+		fs.Duration("timeout", time.Second, "")
+		for i, name := range []string{"answer-delay-per-type", "view", "source-port-map", "rewrite", "type-confuse"} {
+			fs.Var(values[i], name, "")
+		}
+		return fs, values
+	}
+
+	for _, args := range [][]string{
+		nil,
+		{
+			"-answer-delay-per-type", "AAAA:50ms,A:0s",
+			"-view", "a=a.json", "-view", "b=b.json",
+			"-source-port-map", "5353:a,5354:b",
+			"-rewrite", "A:10.0.0.1=10.0.0.2", "-rewrite", "A:10.0.0.3=10.0.0.4",
+			"-type-confuse", "www.example.com:A=TXT", "-type-confuse", "example.com:MX=A",
+		},
+	} {
+		fs, values := newFlags()
+		err := fs.Parse(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := ioutil.TempFile("", "mockdns")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		err = dumpConfig(f, fs)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loaded, loadedValues := newFlags()
+		err = loadConfigFile(loaded, f.Name())
+		if err != nil {
+			b, _ := ioutil.ReadFile(f.Name())
+			t.Fatalf("loading dump %q: %v", b, err)
+		}
+		for i, v := range values {
+			if actual := loadedValues[i].String(); actual != v.String() {
+				t.Errorf("expected %q; actual: %q", v, actual)
+			}
+		}
+	}
+}
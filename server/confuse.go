@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// confusion identifies a name and query type answered with another type.
+type confusion struct {
+	name  string
+	qtype uint16
+}
+
+// typeConfusions is a flag.Value mapping queries for a name and type to the
+// name's local records of a substitute type, set by repeated NAME:TYPE=TYPE
+// arguments (e.g. www.example.com:A=TXT). It lets tests check that clients
+// reject answers of the wrong type.
+type typeConfusions map[confusion]uint16
+
+func (tc typeConfusions) String() string {
+	return strings.Join(tc.values(), ",")
+}
+
+func (tc typeConfusions) values() []string {
+	s := make([]string, 0, len(tc))
+	for c, subst := range tc {
+		s = append(s, fmt.Sprintf("%s:%s=%s", c.name, dns.TypeToString[c.qtype], dns.TypeToString[subst]))
+	}
+	sort.Strings(s)
+
+	return s
+}
+
+func (tc typeConfusions) Set(s string) error {
+	i := strings.Index(s, ":")
+	j := strings.Index(s, "=")
+	if i < 1 || j < i+2 || j == len(s)-1 {
+		return fmt.Errorf("expected NAME:TYPE=TYPE; actual: %q", s)
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(s[i+1:j])]
+	if !ok {
+		return fmt.Errorf("unknown query type in type confusion %q", s)
+	}
+	subst, ok := dns.StringToType[strings.ToUpper(s[j+1:])]
+	if !ok {
+		return fmt.Errorf("unknown substitute type in type confusion %q", s)
+	}
+	tc[confusion{dns.Fqdn(strings.ToLower(s[:i])), qtype}] = subst
+
+	return nil
+}
+
+// answerType returns the type of records answering a query for name and
+// qtype: the substitute type if one is set, or else qtype.
+func (tc typeConfusions) answerType(name string, qtype uint16) uint16 {
+	if subst, ok := tc[confusion{strings.ToLower(name), qtype}]; ok {
+		return subst
+	}
+
+	return qtype
+}
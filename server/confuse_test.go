@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTypeConfusions(t *testing.T) {
+	defer func(v typeConfusions) { typeConfuse = v }(typeConfuse)
+	typeConfuse = make(typeConfusions)
+
+	if err := typeConfuse.Set("WWW.test.com:a=TXT"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"www.test.com", "www.test.com:A", ":A=TXT", "www.test.com:BOGUS=TXT", "www.test.com:A=BOGUS"} {
+		if err := make(typeConfusions).Set(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}, {"hostname": "mail", "value": "10.0.0.2"}],
+		"txt": [{"hostname": "www", "value": "not an address"}]
+	}}`)
+	h := handler(d["test.com."])
+
+	m := query(h, "www.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected 1 answer; actual: %v", m.Answer)
+	}
+	if _, ok := m.Answer[0].(*dns.TXT); !ok {
+		t.Fatalf("expected TXT answer to A query; actual: %v", m.Answer[0])
+	}
+
+	// Other names are answered normally.
+	m = query(h, "mail.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Rrtype != dns.TypeA {
+		t.Fatalf("expected A answer; actual: %v", m.Answer)
+	}
+}
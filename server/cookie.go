@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	clientCookieLen = 8
+	serverCookieLen = 16
+)
+
+// requestCookie returns the raw EDNS0 COOKIE option (RFC 7873) from r, if
+// any.
+func requestCookie(r *dns.Msg) ([]byte, bool) {
+	o := r.IsEdns0()
+	if o == nil {
+		return nil, false
+	}
+
+	for _, opt := range o.Option {
+		if c, ok := opt.(*dns.EDNS0_COOKIE); ok {
+			b, _ := hex.DecodeString(c.Cookie)
+			return b, true
+		}
+	}
+
+	return nil, false
+}
+
+// serverCookie derives the server cookie for the client cookie and client
+// address from secret.
+func serverCookie(secret string, clientCookie []byte, client net.Addr) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(clientCookie)
+	if host, _, err := net.SplitHostPort(client.String()); err == nil {
+		mac.Write(net.ParseIP(host))
+	}
+
+	return mac.Sum(nil)[:serverCookieLen]
+}
+
+// checkCookie returns the rcode for the cookie in r from client: FORMERR for
+// a malformed cookie, BADCOOKIE for a server cookie not derived from secret,
+// and NOERROR otherwise, including when r has no cookie or only a client
+// cookie.
+func checkCookie(secret string, client net.Addr, r *dns.Msg) int {
+	b, ok := requestCookie(r)
+	switch {
+	case !ok:
+		return dns.RcodeSuccess
+	case len(b) == clientCookieLen:
+		return dns.RcodeSuccess
+	case len(b) < clientCookieLen+8 || len(b) > clientCookieLen+32:
+		return dns.RcodeFormatError
+	}
+
+	expected := serverCookie(secret, b[:clientCookieLen], client)
+	if !hmac.Equal(b[clientCookieLen:], expected) {
+		return dns.RcodeBadCookie
+	}
+
+	return dns.RcodeSuccess
+}
+
+// addCookie replaces any COOKIE option in m with the client cookie from r
+// and a fresh server cookie for client. Replies to requests without a
+// client cookie are left as-is.
+func addCookie(secret string, client net.Addr, r, m *dns.Msg) {
+	b, _ := requestCookie(r)
+	if len(b) < clientCookieLen {
+		return
+	}
+	cc := b[:clientCookieLen]
+
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(r.IsEdns0().UDPSize(), false)
+		o = m.IsEdns0()
+	}
+
+	opts := o.Option[:0]
+	for _, opt := range o.Option {
+		if opt.Option() != dns.EDNS0COOKIE {
+			opts = append(opts, opt)
+		}
+	}
+	o.Option = append(opts, &dns.EDNS0_COOKIE{
+		Code:   dns.EDNS0COOKIE,
+		Cookie: hex.EncodeToString(append(append([]byte{}, cc...), serverCookie(secret, cc, client)...)),
+	})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// cookieQuery returns an A query for name carrying the hex-encoded cookie.
+func cookieQuery(name, cookie string) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(name, dns.TypeA)
+	r.SetEdns0(dns.DefaultMsgSize, false)
+	o := r.IsEdns0()
+	o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+
+	return r
+}
+
+// replyCookie returns the hex-encoded cookie in m, failing the test if
+// there isn't one.
+func replyCookie(t *testing.T, m *dns.Msg) string {
+	t.Helper()
+
+	if o := m.IsEdns0(); o != nil {
+		for _, opt := range o.Option {
+			if c, ok := opt.(*dns.EDNS0_COOKIE); ok {
+				return c.Cookie
+			}
+		}
+	}
+	t.Fatalf("expected cookie in reply: %v", m)
+
+	return ""
+}
+
+// extendedRcode returns the full rcode of m, including the upper bits from
+// its OPT record.
+func extendedRcode(m *dns.Msg) int {
+	o := m.IsEdns0()
+	if o == nil {
+		return m.Rcode
+	}
+
+	return int(o.Hdr.Ttl>>24)<<4 | m.Rcode&0xF
+}
+
+func TestCookies(t *testing.T) {
+	defer func(s string, e bool) { cookieSecret, cookieEnforce = s, e }(cookieSecret, cookieEnforce)
+	cookieSecret, cookieEnforce = "test secret", true
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts, client := NewTestServer(t, d)
+
+	const clientCookie = "0102030405060708"
+
+	// The first query carries only a client cookie.
+	m, _, err := client.Exchange(cookieQuery("test.com.", clientCookie), ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected answer; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+	cookie := replyCookie(t, m)
+	if len(cookie) != 2*(clientCookieLen+serverCookieLen) || cookie[:2*clientCookieLen] != clientCookie {
+		t.Fatalf("expected client cookie followed by server cookie; actual: %s", cookie)
+	}
+
+	// Presenting the server cookie is accepted and yields the same cookie.
+	m, _, err = client.Exchange(cookieQuery("test.com.", cookie), ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected answer; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+	if actual := replyCookie(t, m); actual != cookie {
+		t.Fatalf("expected cookie %s; actual: %s", cookie, actual)
+	}
+
+	// A forged server cookie is rejected along with a fresh server cookie.
+	forged := clientCookie + hex.EncodeToString(make([]byte, serverCookieLen))
+	m, _, err = client.Exchange(cookieQuery("test.com.", forged), ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcode := extendedRcode(m); rcode != dns.RcodeBadCookie {
+		t.Fatalf("expected BADCOOKIE; actual: %s", dns.RcodeToString[rcode])
+	}
+	if len(m.Answer) != 0 {
+		t.Fatalf("expected no answers; actual: %v", m.Answer)
+	}
+	if actual := replyCookie(t, m); actual != cookie {
+		t.Fatalf("expected fresh cookie %s; actual: %s", cookie, actual)
+	}
+}
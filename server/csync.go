@@ -0,0 +1,49 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// CSYNC flags (RFC 7477 2.1.1.2).
+const (
+	csyncImmediate  = 1 << 0
+	csyncSOAMinimum = 1 << 1
+)
+
+// typeNumbers maps type names to type numbers, reversing dns.TypeToString.
+var typeNumbers = func() map[string]uint16 {
+	m := make(map[string]uint16, len(dns.TypeToString))
+	for typ, name := range dns.TypeToString {
+		m[name] = typ
+	}
+
+	return m
+}()
+
+// csyncFields returns the serial, flags and type bit map of CSYNC record data
+// (RFC 7477 2.1.1) from the serial, flags and type_bitmap fields of m. Flags
+// may only set the immediate (1) and soaminimum (2) bits.
+func csyncFields(m map[string]string) (string, error) {
+	if _, err := strconv.ParseUint(m[keySerial], 10, 32); err != nil {
+		return "", fmt.Errorf("invalid %s %q", keySerial, m[keySerial])
+	}
+
+	flags, err := strconv.ParseUint(m[keyFlags], 10, 16)
+	if err != nil || flags&^(csyncImmediate|csyncSOAMinimum) != 0 {
+		return "", fmt.Errorf("invalid %s %q", keyFlags, m[keyFlags])
+	}
+
+	fields := []string{m[keySerial], m[keyFlags]}
+	for _, name := range strings.Fields(m[keyTypeBitmap]) {
+		if _, ok := typeNumbers[strings.ToUpper(name)]; !ok {
+			return "", fmt.Errorf("unknown type %q in %s", name, keyTypeBitmap)
+		}
+		fields = append(fields, strings.ToUpper(name))
+	}
+
+	return strings.Join(fields, " "), nil
+}
@@ -0,0 +1,20 @@
+package server
+
+import "github.com/miekg/dns"
+
+// debugName owns the TXT record added to replies by -debug-extra.
+const debugName = "debug.mockdns."
+
+// addDebugTXT appends a CHAOS-class TXT record to m's additional section
+// naming the domain that matched the request, the source of the reply (local
+// or proxied) and the server ID.
+func addDebugTXT(m *dns.Msg, domain, source string) {
+	m.Extra = append(m.Extra, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   debugName,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassCHAOS,
+		},
+		Txt: []string{"domain=" + domain, "source=" + source, "server=" + serverID},
+	})
+}
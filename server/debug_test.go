@@ -0,0 +1,46 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDebugExtra(t *testing.T) {
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}))
+
+	defer func(v bool, id string) { debugExtra, serverID = v, id }(debugExtra, serverID)
+	debugExtra, serverID = true, "test-instance"
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`)
+
+	for _, tc := range []struct {
+		h        func(dns.ResponseWriter, *dns.Msg)
+		name     string
+		expected []string
+	}{
+		{handler(d["test.com."]), "www.test.com.", []string{"domain=test.com.", "source=local", "server=test-instance"}},
+		{proxyHandler, "proxied.test.", []string{"domain=.", "source=proxied", "server=test-instance"}},
+	} {
+		m := query(tc.h, tc.name, dns.TypeA)
+
+		var txt *dns.TXT
+		for _, rr := range m.Extra {
+			if rr.Header().Name == debugName {
+				txt, _ = rr.(*dns.TXT)
+			}
+		}
+		if txt == nil {
+			t.Errorf("%s: expected debug TXT in additional section; actual: %v", tc.name, m.Extra)
+			continue
+		}
+		if !reflect.DeepEqual(txt.Txt, tc.expected) {
+			t.Errorf("%s: expected %q; actual: %q", tc.name, tc.expected, txt.Txt)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import "github.com/miekg/dns"
+
+// dnssecTypes are the record types removed by stripDNSSEC.
+var dnssecTypes = map[uint16]bool{
+	dns.TypeRRSIG:   true,
+	dns.TypeNSEC:    true,
+	dns.TypeNSEC3:   true,
+	dns.TypeDNSKEY:  true,
+	dns.TypeDS:      true,
+	dns.TypeCDS:     true,
+	dns.TypeCDNSKEY: true,
+}
+
+// stripDNSSEC removes DNSSEC records from every section of m and clears its
+// AD bit.
+func stripDNSSEC(m *dns.Msg) {
+	m.Answer = withoutDNSSEC(m.Answer)
+	m.Ns = withoutDNSSEC(m.Ns)
+	m.Extra = withoutDNSSEC(m.Extra)
+	m.AuthenticatedData = false
+}
+
+// withoutDNSSEC filters the DNSSEC records out of rrs in place.
+func withoutDNSSEC(rrs []dns.RR) []dns.RR {
+	out := rrs[:0]
+	for _, rr := range rrs {
+		if !dnssecTypes[rr.Header().Rrtype] {
+			out = append(out, rr)
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestStripDNSSEC(t *testing.T) {
+	answer := []dns.RR{
+		mustRR(t, "signed.test. 300 IN A 10.0.0.1"),
+		mustRR(t, "signed.test. 300 IN RRSIG A 8 2 300 20300101000000 20200101000000 12345 test. AAAA"),
+	}
+	ns := []dns.RR{
+		mustRR(t, "test. 300 IN NS ns.test."),
+		mustRR(t, "test. 300 IN DS 12345 8 2 0123456789ABCDEF"),
+		mustRR(t, "test. 300 IN NSEC a.test. A NS RRSIG NSEC"),
+	}
+	extra := []dns.RR{mustRR(t, "test. 300 IN DNSKEY 257 3 8 AwEAAQ==")}
+
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.AuthenticatedData = true
+		m.Answer, m.Ns, m.Extra = answer, ns, extra
+		m.SetEdns0(4096, true)
+		w.WriteMsg(m)
+	}))
+
+	defer func(v bool) { stripDNSSECRRs = v }(stripDNSSECRRs)
+
+	for _, strip := range []bool{false, true} {
+		stripDNSSECRRs = strip
+
+		r := new(dns.Msg)
+		r.SetQuestion("signed.test.", dns.TypeA)
+		r.SetEdns0(4096, true)
+
+		w := new(testResponseWriter)
+		proxyHandler(w, r)
+
+		expected := map[bool]string{
+			false: "[A RRSIG] [NS DS NSEC] [DNSKEY OPT]",
+			true:  "[A] [NS] [OPT]",
+		}[strip]
+		actual := fmt.Sprint(rrTypes(w.msg.Answer), rrTypes(w.msg.Ns), rrTypes(w.msg.Extra))
+		if actual != expected {
+			t.Errorf("strip=%t: expected types %s; actual: %s", strip, expected, actual)
+		}
+		if w.msg.AuthenticatedData == strip {
+			t.Errorf("strip=%t: expected AD %t; actual: %t", strip, !strip, w.msg.AuthenticatedData)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const dohContentType = "application/dns-message"
+
+// dohClient exchanges DNS messages with a DNS-over-HTTPS server (RFC 8484).
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+func newDoHClient(url string, timeout time.Duration) *dohClient {
+	return &dohClient{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         (&net.Dialer{Timeout: timeout}).DialContext,
+				ForceAttemptHTTP2:   true,
+				TLSHandshakeTimeout: timeout,
+			},
+		},
+	}
+}
+
+// Exchange POSTs the wire-format query r to the DoH server and returns its
+// reply.
+func (c *dohClient) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	// RFC 8484 4.1 recommends a zero message ID for cache friendliness.
+	q := r.Copy()
+	q.Id = 0
+
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server %s: %s", c.url, resp.Status)
+	}
+
+	b, err = ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	m := new(dns.Msg)
+	err = m.Unpack(b)
+	if err != nil {
+		return nil, err
+	}
+	m.Id = r.Id
+
+	return m, nil
+}
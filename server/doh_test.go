@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestProxyUpstreamDoH(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohContentType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		r := new(dns.Msg)
+		err = r.Unpack(b)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+
+		b, err = m.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(b)
+	}))
+	defer upstream.Close()
+
+	defer func(p bool, c *dohClient) { proxy, doh = p, c }(proxy, doh)
+	proxy, doh = true, newDoHClient(upstream.URL, time.Second)
+
+	r := new(dns.Msg)
+	r.SetQuestion("proxied.test.", dns.TypeA)
+
+	w := new(testResponseWriter)
+	proxyHandler(w, r)
+
+	if w.msg.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[w.msg.Rcode])
+	}
+	if w.msg.Id != r.Id {
+		t.Errorf("expected message ID %d; actual: %d", r.Id, w.msg.Id)
+	}
+	if len(w.msg.Answer) != 1 {
+		t.Fatalf("expected 1 answer; actual: %v", w.msg.Answer)
+	}
+	if a, ok := w.msg.Answer[0].(*dns.A); !ok || !a.A.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("expected A 192.0.2.1; actual: %s", w.msg.Answer[0])
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/miekg/dns"
+)
+
+// dohPath is the URI path of the DNS-over-HTTPS endpoint (RFC 8484 4.1).
+const dohPath = "/dns-query"
+
+// dohResponseWriter is the dns.ResponseWriter for a query received over
+// DNS-over-HTTPS. It holds the reply for the HTTP response.
+type dohResponseWriter struct {
+	local, remote net.Addr
+	requestID     string
+	reply         []byte
+}
+
+func (w *dohResponseWriter) LocalAddr() net.Addr  { return w.local }
+func (w *dohResponseWriter) RemoteAddr() net.Addr { return w.remote }
+
+func (w *dohResponseWriter) WriteMsg(m *dns.Msg) error {
+	b, err := m.Pack()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+
+	return err
+}
+
+func (w *dohResponseWriter) Write(b []byte) (int, error) {
+	w.reply = append([]byte(nil), b...)
+
+	return len(b), nil
+}
+
+func (w *dohResponseWriter) Close() error        { return nil }
+func (w *dohResponseWriter) TsigStatus() error   { return nil }
+func (w *dohResponseWriter) TsigTimersOnly(bool) {}
+func (w *dohResponseWriter) Hijack()             {}
+
+// requestID returns the ID of the DNS-over-HTTPS request carrying the query
+// answered through w, or an empty string for other transports.
+func requestID(w dns.ResponseWriter) string {
+	switch rw := w.(type) {
+	case *dohResponseWriter:
+		return rw.requestID
+	case *timedResponseWriter:
+		return requestID(rw.ResponseWriter)
+	}
+
+	return ""
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// dohHandler returns an http.Handler answering DNS-over-HTTPS GET and POST
+// requests (RFC 8484) with h. A request's ID is the value of its idHeader
+// header, or a generated UUID if it has none, and is echoed in the response.
+func dohHandler(h dns.Handler, idHeader string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var b []byte
+		var err error
+		switch req.Method {
+		case http.MethodGet:
+			b, err = base64.RawURLEncoding.DecodeString(req.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if req.Header.Get("Content-Type") != dohContentType {
+				http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+				return
+			}
+			b, err = ioutil.ReadAll(io.LimitReader(req.Body, dns.MaxMsgSize))
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		r := new(dns.Msg)
+		if err == nil {
+			err = r.Unpack(b)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("malformed DNS query: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		dw := &dohResponseWriter{requestID: req.Header.Get(idHeader)}
+		if dw.requestID == "" {
+			dw.requestID = newUUID()
+		}
+		if local, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			dw.local = local
+		}
+		if remote, rErr := net.ResolveTCPAddr("tcp", req.RemoteAddr); rErr == nil {
+			dw.remote = remote
+		}
+		if idHeader != "" {
+			w.Header().Set(idHeader, dw.requestID)
+		}
+
+		receivedQueries.received(dw.remote, b)
+		h.ServeDNS(dw, r)
+		if dw.reply == nil {
+			http.Error(w, "no reply", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", dohContentType)
+		_, err = w.Write(dw.reply)
+		if err != nil {
+			log.Printf("Writing DoH response: %s", err)
+		}
+	})
+}
+
+// serveDoH serves DNS-over-HTTPS queries for h on addr until ctx is done,
+// over plain HTTP if cfg is nil.
+func serveDoH(ctx context.Context, addr string, h dns.Handler, cfg *tls.Config) {
+	mux := http.NewServeMux()
+	mux.Handle(dohPath, dohHandler(h, requestIDHeader))
+	server := &http.Server{Addr: addr, Handler: mux, TLSConfig: cfg}
+
+	go func() {
+		<-ctx.Done()
+		err := server.Shutdown(context.Background())
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	var err error
+	log.Printf("DoH listening on %s ...\n", addr)
+	if cfg != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	log.Printf("%s DoH listener stopped\n", addr)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDoHServer(t *testing.T) {
+	exp := new(memoryExporter)
+	defer func(v spanExporter) { tracer = v }(tracer)
+	tracer = exp
+
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer func(v bool) { verbose = v }(verbose)
+	verbose = true
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts := httptest.NewServer(dohHandler(NewServer(d), "X-Request-ID"))
+	defer ts.Close()
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	b, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exchange := func(req *http.Request) (*dns.Msg, string) {
+		t.Helper()
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200; actual: %d", resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != dohContentType {
+			t.Fatalf("expected %s; actual: %s", dohContentType, ct)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := new(dns.Msg)
+		err = m.Unpack(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Answer) != 1 {
+			t.Fatalf("expected an answer; actual: %v", m.Answer)
+		}
+
+		return m, resp.Header.Get("X-Request-ID")
+	}
+
+	post, err := http.NewRequest(http.MethodPost, ts.URL+dohPath, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Header.Set("Content-Type", dohContentType)
+	post.Header.Set("X-Request-ID", "req-1234")
+	if _, id := exchange(post); id != "req-1234" {
+		t.Errorf("expected the request ID echoed; actual: %q", id)
+	}
+
+	get, err := http.NewRequest(http.MethodGet, ts.URL+dohPath+"?dns="+base64.RawURLEncoding.EncodeToString(b), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, generated := exchange(get)
+	if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(generated) {
+		t.Errorf("expected a generated UUID; actual: %q", generated)
+	}
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("expected a span per query; actual: %d", len(exp.spans))
+	}
+	for i, id := range []string{"req-1234", generated} {
+		if actual := exp.spans[i].Attributes[attrRequestID]; actual != id {
+			t.Errorf("span %d: expected request ID %q; actual: %q", i, id, actual)
+		}
+		if !strings.Contains(buf.String(), "(request ID "+id+")") {
+			t.Errorf("expected request ID %q logged; actual: %s", id, buf.String())
+		}
+	}
+
+	resp, err := http.Post(ts.URL+dohPath, "text/plain", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415; actual: %d", resp.StatusCode)
+	}
+}
+
+func TestDoHRequestIDJSONLog(t *testing.T) {
+	var buf lockedBuffer
+	log.SetOutput(jsonLogWriter{&buf})
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.Flags())
+	log.SetFlags(0)
+	defer func(v bool) { verbose = v }(verbose)
+	verbose = true
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts := httptest.NewServer(dohHandler(NewServer(d), "X-Request-ID"))
+	defer ts.Close()
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	b, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.URL+dohPath, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("X-Request-ID", "req-5678")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	var entry struct {
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+	}
+	err = json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &entry)
+	if err != nil {
+		t.Fatalf("expected a JSON log line: %s: %q", err, buf.String())
+	}
+	if entry.RequestID != "req-5678" {
+		t.Errorf("expected request_id req-5678; actual: %q", entry.RequestID)
+	}
+	if !strings.Contains(entry.Msg, "test.com.") || strings.Contains(entry.Msg, "req-5678") {
+		t.Errorf("expected the query without the request ID in msg; actual: %q", entry.Msg)
+	}
+}
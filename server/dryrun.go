@@ -0,0 +1,76 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// dryRunSummary describes validated record data for -dry-run.
+type dryRunSummary struct {
+	Domains  []domainSummary `json:"domains"`
+	Records  int             `json:"records"`
+	Warnings []string        `json:"warnings"`
+}
+
+// domainSummary counts a domain's records by type.
+type domainSummary struct {
+	Name    string         `json:"name"`
+	Records map[string]int `json:"records"`
+}
+
+func summarize(d data, warnings []string) dryRunSummary {
+	s := dryRunSummary{Domains: []domainSummary{}, Warnings: []string{}}
+	s.Warnings = append(s.Warnings, warnings...)
+
+	for name, recs := range d {
+		ds := domainSummary{Name: name, Records: make(map[string]int)}
+		for _, set := range recs.sets() {
+			for typ, rrs := range set.data {
+				ds.Records[dns.TypeToString[typ]] += len(rrs)
+				s.Records += len(rrs)
+			}
+		}
+		s.Domains = append(s.Domains, ds)
+	}
+	sort.Slice(s.Domains, func(i, j int) bool { return s.Domains[i].Name < s.Domains[j].Name })
+
+	return s
+}
+
+// printSummary writes s to w as JSON for the json format, or as text.
+func printSummary(w io.Writer, s dryRunSummary, format string) error {
+	if format == logFormatJSON {
+		return json.NewEncoder(w).Encode(s)
+	}
+
+	for _, ds := range s.Domains {
+		types := make([]string, 0, len(ds.Records))
+		var n int
+		for typ, count := range ds.Records {
+			types = append(types, fmt.Sprintf("%s=%d", typ, count))
+			n += count
+		}
+		sort.Strings(types)
+
+		_, err := fmt.Fprintf(w, "%s: %d records (%s)\n", ds.Name, n, strings.Join(types, " "))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, warning := range s.Warnings {
+		_, err := fmt.Fprintf(w, "Warning: %s\n", warning)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "%d domains, %d records, %d warnings\n", len(s.Domains), s.Records, len(s.Warnings))
+
+	return err
+}
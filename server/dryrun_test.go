@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDryRunSummary(t *testing.T) {
+	defer func(v bool) { dryRun = v }(dryRun)
+	defer func(v []string) { dryRunWarnings = v }(dryRunWarnings)
+	dryRun, dryRunWarnings = true, nil
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}, {"hostname": "www2", "value": "10.0.0.2"}],
+		"ns": [{"hostname": "@", "value": "ns1.test.com."}]
+	}}`)
+	s := summarize(d, dryRunWarnings)
+
+	var text bytes.Buffer
+	err := printSummary(&text, s, logFormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"test.com.: 3 records (A=2 NS=1)",
+		"Warning: test.com.: NS target ns1.test.com. has no A or AAAA glue record",
+		"1 domains, 3 records, 1 warnings",
+	} {
+		if !strings.Contains(text.String(), expected) {
+			t.Errorf("expected %q in summary; actual:\n%s", expected, text.String())
+		}
+	}
+
+	var buf bytes.Buffer
+	err = printSummary(&buf, s, logFormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual dryRunSummary
+	err = json.Unmarshal(buf.Bytes(), &actual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual.Records != 3 || len(actual.Domains) != 1 || actual.Domains[0].Records["A"] != 2 || len(actual.Warnings) != 1 {
+		t.Fatalf("unexpected JSON summary: %+v", actual)
+	}
+}
+
+func BenchmarkDryRun(b *testing.B) {
+	var sb strings.Builder
+	sb.WriteString(`{"test.com.": {"a": [`)
+	for i := 0; i < 10000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"hostname": "h%d", "value": "10.%d.%d.%d"}`, i, i>>16&0xff, i>>8&0xff, i&0xff)
+	}
+	sb.WriteString(`]}}`)
+	j := []byte(sb.String())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := make(data)
+		err := json.Unmarshal(j, &d)
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = printSummary(ioutil.Discard, summarize(d, nil), logFormatJSON)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDryRunLoadWarnings(t *testing.T) {
+	defer func(v bool) { dryRun = v }(dryRun)
+	defer func(v []string) { dryRunWarnings = v }(dryRunWarnings)
+	defer func(v bool) { noFatalParse = v }(noFatalParse)
+	dryRun, dryRunWarnings, noFatalParse = true, nil, true
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}, {"hostname": "bad", "value": "not-an-ip"}],
+		"bogus": [{"hostname": "@", "value": "x"}]
+	}}`)
+	s := summarize(d, dryRunWarnings)
+
+	for _, expected := range []string{
+		`skipping unsupported type "BOGUS" for test.com.`,
+		"skipping invalid record in test.com.",
+	} {
+		var found bool
+		for _, w := range s.Warnings {
+			found = found || strings.HasPrefix(w, expected)
+		}
+		if !found {
+			t.Errorf("expected warning %q; actual: %q", expected, s.Warnings)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/hex"
+
+	"github.com/miekg/dns"
+)
+
+// doBit reports whether r has the DNSSEC OK bit set.
+func doBit(r *dns.Msg) bool {
+	o := r.IsEdns0()
+	return o != nil && o.Do()
+}
+
+// ednsVersion returns the EDNS version of r, or -1 if r has no OPT record.
+func ednsVersion(r *dns.Msg) int {
+	o := r.IsEdns0()
+	if o == nil {
+		return -1
+	}
+
+	return int(o.Version())
+}
+
+// echoEdns0 gives the reply m to an EDNS0 request r an OPT record (RFC 6891
+// 7) if it lacks one, with r's DO bit (RFC 3225 3) and no other flags set.
+func echoEdns0(r, m *dns.Msg) {
+	ro := r.IsEdns0()
+	if ro == nil {
+		return
+	}
+
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(ro.UDPSize(), false)
+		o = m.IsEdns0()
+	}
+	o.SetVersion(0)
+	o.Hdr.Ttl &^= 0xFFFF
+	o.SetDo(ro.Do())
+}
+
+// addNSID replaces any NSID option in m with one carrying nsid if r asks for
+// it with an NSID option (RFC 5001 2.1).
+func addNSID(nsid string, r, m *dns.Msg) {
+	ro := r.IsEdns0()
+	if ro == nil {
+		return
+	}
+	requested := false
+	for _, opt := range ro.Option {
+		requested = requested || opt.Option() == dns.EDNS0NSID
+	}
+	if !requested {
+		return
+	}
+
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(ro.UDPSize(), false)
+		o = m.IsEdns0()
+	}
+
+	opts := o.Option[:0]
+	for _, opt := range o.Option {
+		if opt.Option() != dns.EDNS0NSID {
+			opts = append(opts, opt)
+		}
+	}
+	o.Option = append(opts, &dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: hex.EncodeToString([]byte(nsid))})
+}
+
+// padResponse appends an EDNS0 padding option (RFC 7830) to m bringing its
+// wire size up to size bytes. Only replies to EDNS0 requests are padded, and
+// replies already at or beyond size are left as-is.
+func padResponse(r, m *dns.Msg, size int) {
+	ro := r.IsEdns0()
+	if ro == nil {
+		return
+	}
+
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(ro.UDPSize(), false)
+		o = m.IsEdns0()
+	}
+
+	p := new(dns.EDNS0_PADDING)
+	o.Option = append(o.Option, p)
+
+	// Msg.Len is only an estimate, so measure the packed message instead.
+	b, err := m.Pack()
+	if err != nil || len(b) > size {
+		o.Option = o.Option[:len(o.Option)-1]
+		return
+	}
+	p.Padding = make([]byte, size-len(b))
+}
+
+// setExtendedRcode stores the upper 8 bits of m's rcode in its OPT record
+// (RFC 6891 6.1.3), which the vendored dns package only does for rcodes of
+// 256 and above.
+func setExtendedRcode(m *dns.Msg) {
+	if m.Rcode <= 0xF {
+		return
+	}
+
+	o := m.IsEdns0()
+	if o == nil {
+		m.SetEdns0(dns.MinMsgSize, false)
+		o = m.IsEdns0()
+	}
+	o.Hdr.Ttl = o.Hdr.Ttl&0x00FFFFFF | uint32(m.Rcode>>4)<<24
+}
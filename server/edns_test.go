@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestPadResponse(t *testing.T) {
+	defer func(v int) { padTo = v }(padTo)
+	padTo = 468
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	r.SetEdns0(4096, false)
+
+	w := new(testResponseWriter)
+	handler(d["test.com."])(w, r)
+
+	o := w.msg.IsEdns0()
+	if o == nil {
+		t.Fatal("expected OPT record in response")
+	}
+
+	var padded bool
+	for _, opt := range o.Option {
+		if _, ok := opt.(*dns.EDNS0_PADDING); ok {
+			padded = true
+		}
+	}
+	if !padded {
+		t.Fatal("expected padding option in OPT record")
+	}
+
+	b, err := w.msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != padTo {
+		t.Fatalf("expected %d byte response; actual: %d", padTo, len(b))
+	}
+}
+
+func TestPadResponseWithoutEDNS(t *testing.T) {
+	t.Parallel()
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	padResponse(r, m, 468)
+
+	if m.IsEdns0() != nil {
+		t.Fatal("expected no OPT record in response to non-EDNS0 request")
+	}
+}
+
+func TestBadVers(t *testing.T) {
+	ts, client := NewTestServer(t, loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.test.com.", dns.TypeA)
+	r.SetEdns0(4096, true)
+	r.IsEdns0().SetVersion(1)
+
+	m, _, err := client.Exchange(r, ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rcode := extendedRcode(m); rcode != dns.RcodeBadVers {
+		t.Fatalf("expected BADVERS; actual: %d", rcode)
+	}
+	o := m.IsEdns0()
+	if o == nil || o.Version() != 0 {
+		t.Fatalf("expected an OPT record with version 0; actual: %v", o)
+	}
+	if len(m.Answer) != 0 {
+		t.Fatalf("expected no answers; actual: %v", m.Answer)
+	}
+}
+
+func TestEchoDO(t *testing.T) {
+	ts, client := NewTestServer(t, loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+
+	for _, do := range []bool{true, false} {
+		r := new(dns.Msg)
+		r.SetQuestion("www.test.com.", dns.TypeA)
+		r.SetEdns0(4096, do)
+		r.IsEdns0().Hdr.Ttl |= 0x0001 // an unknown Z flag
+
+		m, _, err := client.Exchange(r, ts.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		o := m.IsEdns0()
+		switch {
+		case o == nil:
+			t.Fatalf("DO=%t: expected an OPT record", do)
+		case o.Do() != do:
+			t.Errorf("expected DO=%t; actual: %t", do, o.Do())
+		case o.Hdr.Ttl&0x7FFF != 0:
+			t.Errorf("DO=%t: expected Z flags cleared; actual: %#x", do, o.Hdr.Ttl&0x7FFF)
+		}
+	}
+}
+
+func TestNSID(t *testing.T) {
+	defer func(v string) { nsid = v }(nsid)
+	nsid = "mockdns-1"
+
+	ts, client := NewTestServer(t, loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+
+	for _, requested := range []bool{true, false} {
+		r := new(dns.Msg)
+		r.SetQuestion("www.test.com.", dns.TypeA)
+		r.SetEdns0(4096, false)
+		if requested {
+			o := r.IsEdns0()
+			o.Option = append(o.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+		}
+
+		m, _, err := client.Exchange(r, ts.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var actual string
+		for _, opt := range m.IsEdns0().Option {
+			if o, ok := opt.(*dns.EDNS0_NSID); ok {
+				b, err := hex.DecodeString(o.Nsid)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = string(b)
+			}
+		}
+		switch {
+		case requested && actual != "mockdns-1":
+			t.Errorf("expected NSID %q; actual: %q", "mockdns-1", actual)
+		case !requested && actual != "":
+			t.Errorf("expected no NSID when not requested; actual: %q", actual)
+		}
+	}
+}
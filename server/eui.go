@@ -0,0 +1,23 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// checkEUI returns an error unless v is a MAC address of the given number of
+// octets written as dash-separated hex pairs, as EUI48 and EUI64 record data
+// are (RFC 7043 3.2 and 4.2).
+func checkEUI(v string, octets int) error {
+	pairs := strings.Split(v, "-")
+	if len(pairs) != octets {
+		return fmt.Errorf("expected %d dash-separated octets: %q", octets, v)
+	}
+	for _, p := range pairs {
+		if len(p) != 2 || strings.Trim(p, "0123456789abcdefABCDEF") != "" {
+			return fmt.Errorf("invalid octet %q in %q", p, v)
+		}
+	}
+
+	return nil
+}
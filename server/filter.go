@@ -0,0 +1,38 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Filter returns a new data holding the domains of d for which predicate
+// returns true. The records themselves are shared with d, not copied.
+func (d data) Filter(predicate func(domain string, rt records) bool) data {
+	f := make(data)
+	for domain, rt := range d {
+		if predicate(domain, rt) {
+			f[domain] = rt
+		}
+	}
+
+	return f
+}
+
+// ByType matches domains with at least one record of qtype.
+func ByType(qtype uint16) func(string, records) bool {
+	return func(_ string, rt records) bool {
+		return len(rt.data[qtype]) > 0
+	}
+}
+
+// ByDomainSuffix matches domains equal to or under suffix, with or without
+// its leading dot, e.g. ".internal" matches both "internal." and
+// "corp.internal.".
+func ByDomainSuffix(suffix string) func(string, records) bool {
+	parent := dns.Fqdn(strings.TrimPrefix(suffix, "."))
+
+	return func(domain string, _ records) bool {
+		return dns.IsSubDomain(parent, domain)
+	}
+}
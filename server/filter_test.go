@@ -0,0 +1,50 @@
+package server
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestDataFilter(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{
+		"test.com.": {"a": [{"value": "10.0.0.1"}]},
+		"corp.internal.": {"mx": [{"value": "mail.corp.internal.", "priority": "10"}]},
+		"Build.Internal.": {"a": [{"value": "10.0.0.2"}]},
+		"notinternal.": {"a": [{"value": "10.0.0.3"}]}
+	}`)
+
+	domains := func(d data) []string {
+		var s []string
+		for domain := range d {
+			s = append(s, domain)
+		}
+		sort.Strings(s)
+		return s
+	}
+
+	for _, tc := range []struct {
+		name      string
+		predicate func(string, records) bool
+		expected  []string
+	}{
+		{"A", ByType(dns.TypeA), []string{"build.internal.", "notinternal.", "test.com."}},
+		{"MX", ByType(dns.TypeMX), []string{"corp.internal."}},
+		{"TXT", ByType(dns.TypeTXT), nil},
+		{".internal", ByDomainSuffix(".internal"), []string{"build.internal.", "corp.internal."}},
+		{"INTERNAL.", ByDomainSuffix("INTERNAL."), []string{"build.internal.", "corp.internal."}},
+		{"root", ByDomainSuffix("."), []string{"build.internal.", "corp.internal.", "notinternal.", "test.com."}},
+	} {
+		if actual := domains(d.Filter(tc.predicate)); !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("%s: expected %v; actual: %v", tc.name, tc.expected, actual)
+		}
+	}
+
+	if len(d) != 4 {
+		t.Errorf("expected Filter to leave d unchanged; actual: %d domains", len(d))
+	}
+}
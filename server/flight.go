@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// flightGroup coalesces concurrent calls sharing a key into a single call
+// whose result every caller receives.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	wg       sync.WaitGroup
+	m        *dns.Msg
+	upstream string
+	err      error
+}
+
+// Do calls fn unless a call for key is already in flight, in which case it
+// waits for that call and returns its result: the reply, the upstream that
+// sent it, and any error. Callers must not modify the returned message
+// without copying it first.
+func (g *flightGroup) Do(key string, fn func() (*dns.Msg, string, error)) (*dns.Msg, string, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	if f, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		f.wg.Wait()
+		return f.m, f.upstream, f.err
+	}
+
+	f := new(flight)
+	f.wg.Add(1)
+	g.calls[key] = f
+	g.mu.Unlock()
+
+	f.m, f.upstream, f.err = fn()
+	f.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return f.m, f.upstream, f.err
+}
+
+// flightKey identifies the questions in r by name, type, and class, followed
+// by the advertised EDNS UDP size and the DO and CD bits when set, since they
+// change the reply.
+func flightKey(r *dns.Msg) string {
+	keys := make([]string, len(r.Question))
+	for i, q := range r.Question {
+		keys[i] = strings.ToLower(q.Name) + "/" + dns.TypeToString[q.Qtype] + "/" + dns.ClassToString[q.Qclass]
+	}
+	key := strings.Join(keys, ",")
+	if o := r.IsEdns0(); o != nil {
+		key += "+edns" + strconv.Itoa(int(o.UDPSize()))
+	}
+	if doBit(r) {
+		key += "+do"
+	}
+	if r.CheckingDisabled {
+		key += "+cd"
+	}
+
+	return key
+}
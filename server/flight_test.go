@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestProxyDeduplication(t *testing.T) {
+	var requests int32
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(200 * time.Millisecond)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		w.WriteMsg(m)
+	}))
+
+	const n = 50
+	var wg sync.WaitGroup
+	msgs := make([]*dns.Msg, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := new(dns.Msg)
+			r.SetQuestion("proxied.test.", dns.TypeA)
+			if i%2 == 1 {
+				r.Question[0].Name = "PROXIED.test."
+			}
+			r.Id = uint16(i + 1)
+
+			w := new(testResponseWriter)
+			proxyHandler(w, r)
+			msgs[i] = w.msg
+		}(i)
+	}
+	wg.Wait()
+
+	if actual := atomic.LoadInt32(&requests); actual != 1 {
+		t.Fatalf("expected 1 upstream request; actual: %d", actual)
+	}
+	for i, m := range msgs {
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			t.Fatalf("response %d: expected answer; actual: %s %v", i, dns.RcodeToString[m.Rcode], m.Answer)
+		}
+		if m.Id != uint16(i+1) {
+			t.Fatalf("response %d: expected ID %d; actual: %d", i, i+1, m.Id)
+		}
+		if name := m.Question[0].Name; (i%2 == 1) != (name == "PROXIED.test.") {
+			t.Fatalf("response %d: expected the request's question case; actual: %s", i, name)
+		}
+	}
+}
+
+func TestProxyDeduplicationEDNS(t *testing.T) {
+	var requests int32
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(200 * time.Millisecond)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		if r.IsEdns0() != nil {
+			m.SetEdns0(4096, false)
+		}
+		w.WriteMsg(m)
+	}))
+
+	var wg sync.WaitGroup
+	msgs := make([]*dns.Msg, 2)
+	for i := range msgs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := new(dns.Msg)
+			r.SetQuestion("edns.test.", dns.TypeA)
+			if i == 1 {
+				r.SetEdns0(1232, false)
+			}
+
+			w := new(testResponseWriter)
+			proxyHandler(w, r)
+			msgs[i] = w.msg
+		}(i)
+	}
+	wg.Wait()
+
+	if actual := atomic.LoadInt32(&requests); actual != 2 {
+		t.Fatalf("expected an upstream request each for EDNS and non-EDNS; actual: %d", actual)
+	}
+	if o := msgs[0].IsEdns0(); o != nil {
+		t.Errorf("expected no OPT record for the non-EDNS query; actual: %s", o)
+	}
+	if msgs[1].IsEdns0() == nil {
+		t.Error("expected an OPT record for the EDNS query")
+	}
+}
+
+func TestFlightKey(t *testing.T) {
+	t.Parallel()
+
+	r := new(dns.Msg)
+	r.SetQuestion("Example.COM.", dns.TypeA)
+	keys := map[string]bool{flightKey(r): true}
+
+	cd := r.Copy()
+	cd.CheckingDisabled = true
+	keys[flightKey(cd)] = true
+
+	do := r.Copy()
+	do.SetEdns0(1232, true)
+	keys[flightKey(do)] = true
+
+	edns := r.Copy()
+	edns.SetEdns0(1232, false)
+	keys[flightKey(edns)] = true
+
+	large := r.Copy()
+	large.SetEdns0(4096, false)
+	keys[flightKey(large)] = true
+
+	if len(keys) != 5 {
+		t.Fatalf("expected distinct keys for EDNS, its UDP size and the DO and CD bits; actual: %v", keys)
+	}
+
+	lower := r.Copy()
+	lower.Question[0].Name = "example.com."
+	if flightKey(lower) != flightKey(r) {
+		t.Errorf("expected names to share a key regardless of case; actual: %s", flightKey(lower))
+	}
+}
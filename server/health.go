@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// healthChecker periodically probes upstream servers so proxied queries can
+// skip those that stop answering. It's safe for concurrent use.
+type healthChecker struct {
+	probe func(addr string) error
+
+	mu   sync.RWMutex
+	dead map[string]bool
+}
+
+// newHealthChecker returns a healthChecker that considers a server alive
+// while probe returns nil for it.
+func newHealthChecker(probe func(addr string) error) *healthChecker {
+	return &healthChecker{probe: probe, dead: make(map[string]bool)}
+}
+
+// exchangeProbe returns a probe asking a server for the root NS records with
+// c. Any reply, whatever its rcode, shows the server is alive.
+func exchangeProbe(c *dns.Client) func(string) error {
+	return func(addr string) error {
+		r := new(dns.Msg)
+		r.SetQuestion(".", dns.TypeNS)
+		_, _, err := c.Exchange(r, addr)
+
+		return err
+	}
+}
+
+// run probes servers every interval until ctx is done.
+func (hc *healthChecker) run(ctx context.Context, servers []string, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		hc.check(servers)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// check probes each of servers once, concurrently, logging every server that
+// goes down or comes back up.
+func (hc *healthChecker) check(servers []string) {
+	var wg sync.WaitGroup
+	for _, addr := range servers {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+
+			err := hc.probe(addr)
+
+			hc.mu.Lock()
+			wasDead := hc.dead[addr]
+			hc.dead[addr] = err != nil
+			hc.mu.Unlock()
+
+			switch {
+			case err != nil && !wasDead:
+				log.Printf("Upstream %s is down: %s", addr, err)
+			case err == nil && wasDead:
+				log.Printf("Upstream %s is back up", addr)
+			}
+		}(addr)
+	}
+	wg.Wait()
+}
+
+// alive returns the servers not known to be dead. If every server is dead,
+// it returns them all so queries are still attempted.
+func (hc *healthChecker) alive(servers []string) []string {
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	out := make([]string, 0, len(servers))
+	for _, addr := range servers {
+		if !hc.dead[addr] {
+			out = append(out, addr)
+		}
+	}
+	if len(out) == 0 {
+		return servers
+	}
+
+	return out
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestUpstreamHealth(t *testing.T) {
+	var failing int32
+	var queries int32
+	flaky := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if atomic.LoadInt32(&failing) == 1 {
+			return // never answer
+		}
+		if r.Question[0].Name != "." {
+			atomic.AddInt32(&queries, 1)
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	_, port, _ := net.SplitHostPort(flaky)
+	healthy := newStubUpstreamAt(t, net.JoinHostPort("127.0.0.2", port), func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	useUpstreams(t, flaky, healthy)
+	client = &dns.Client{Timeout: 100 * time.Millisecond}
+
+	defer func(v *healthChecker) { upstreamHealth = v }(upstreamHealth)
+	upstreamHealth = newHealthChecker(exchangeProbe(client))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		upstreamHealth.run(ctx, upstreamAddrs(clientConfig), 20*time.Millisecond)
+		wg.Done()
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	query(proxyHandler, "before.test.", dns.TypeA)
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Fatalf("expected the healthy first upstream to answer; actual queries: %d", n)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for alive := upstreamHealth.alive(upstreamAddrs(clientConfig)); len(alive) != 1; alive = upstreamHealth.alive(upstreamAddrs(clientConfig)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s marked dead; alive: %v", flaky, alive)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The dead upstream is skipped rather than timing out first.
+	start := time.Now()
+	m := query(proxyHandler, "after.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Fatalf("expected the dead upstream skipped; elapsed: %s", elapsed)
+	}
+}
+
+func TestHealthCheckerAllDead(t *testing.T) {
+	t.Parallel()
+
+	hc := newHealthChecker(func(string) error { return context.DeadlineExceeded })
+	servers := []string{"a", "b"}
+	hc.check(servers)
+
+	if alive := hc.alive(servers); len(alive) != 2 {
+		t.Fatalf("expected every server tried when all are dead; actual: %v", alive)
+	}
+}
+
+func TestSetupProxyHealthProbe(t *testing.T) {
+	addr := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	useUpstreams(t, addr)
+
+	f, err := ioutil.TempFile("", "resolv.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.WriteString("nameserver 127.0.0.1\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	defer func(v string) { resolvConfFile = v }(resolvConfFile)
+	defer func(v bool) { localOnly = v }(localOnly)
+	defer func(v string) { proxyDoH = v }(proxyDoH)
+	defer func(v time.Duration) { upstreamHealthInterval = v }(upstreamHealthInterval)
+	defer func(v *healthChecker) { upstreamHealth = v }(upstreamHealth)
+	resolvConfFile = f.Name()
+	proxy, localOnly, proxyDoH = true, false, ""
+	upstreamHealthInterval = time.Second
+
+	if err = setupProxy(); err != nil {
+		t.Fatal(err)
+	}
+	if upstreamHealth == nil {
+		t.Fatal("expected a health checker")
+	}
+	if err = upstreamHealth.probe(addr); err != nil {
+		t.Fatalf("expected the probe to reach the upstream; actual: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DistParams parameterizes a response delay distribution.
+type DistParams struct {
+	Mean   time.Duration
+	StdDev time.Duration
+	Min    time.Duration
+	Max    time.Duration
+}
+
+// checkDistribution returns an error if dist isn't a supported response
+// delay distribution or params are invalid for it.
+func checkDistribution(dist string, params DistParams) error {
+	switch dist {
+	case "":
+	case "normal":
+		if params.Mean < 0 || params.StdDev < 0 {
+			return fmt.Errorf("normal distribution requires non-negative mean and stddev")
+		}
+	case "uniform":
+		if params.Min < 0 || params.Max < params.Min {
+			return fmt.Errorf("uniform distribution requires 0 <= min <= max")
+		}
+	default:
+		return fmt.Errorf("unknown response delay distribution %q", dist)
+	}
+
+	return nil
+}
+
+// sampleLatency draws a response delay from the named distribution. Negative
+// samples are clamped to zero, and an empty or unknown distribution always
+// yields zero.
+func sampleLatency(dist string, params DistParams, rng *rand.Rand) time.Duration {
+	var d time.Duration
+	switch dist {
+	case "normal":
+		d = params.Mean + time.Duration(rng.NormFloat64()*float64(params.StdDev))
+	case "uniform":
+		d = params.Min + time.Duration(rng.Int63n(int64(params.Max-params.Min)+1))
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	return d
+}
+
+// responseDelay samples the configured response delay distribution using
+// the shared random number generator.
+func responseDelay() time.Duration {
+	if delayDist == "" {
+		return 0
+	}
+
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	return sampleLatency(delayDist, delayParams, rng)
+}
+
+// typeDelays is a flag.Value of per-query-type response delays, set by
+// comma-separated TYPE:duration pairs (e.g. AAAA:50ms,A:0ms).
+type typeDelays map[uint16]time.Duration
+
+func (td typeDelays) String() string {
+	s := make([]string, 0, len(td))
+	for typ, d := range td {
+		s = append(s, fmt.Sprintf("%s:%s", dns.TypeToString[typ], d))
+	}
+	sort.Strings(s)
+
+	return strings.Join(s, ",")
+}
+
+func (td typeDelays) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, ":")
+		if i < 1 {
+			return fmt.Errorf("expected TYPE:duration; actual: %q", pair)
+		}
+
+		typ, ok := dns.StringToType[strings.ToUpper(pair[:i])]
+		if !ok {
+			return fmt.Errorf("unknown type in answer delay %q", pair)
+		}
+		d, err := time.ParseDuration(pair[i+1:])
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid duration in answer delay %q", pair)
+		}
+		td[typ] = d
+	}
+
+	return nil
+}
+
+// delay returns the longest delay for the types of the questions in r.
+func (td typeDelays) delay(r *dns.Msg) time.Duration {
+	var max time.Duration
+	for _, q := range r.Question {
+		if d := td[q.Qtype]; d > max {
+			max = d
+		}
+	}
+
+	return max
+}
+
+// flooredWriter delays writing a reply until a minimum latency has passed
+// since the request was received.
+type flooredWriter struct {
+	dns.ResponseWriter
+	until time.Time
+}
+
+// WriteMsg writes m once the latency floor is reached.
+func (w flooredWriter) WriteMsg(m *dns.Msg) error {
+	time.Sleep(time.Until(w.until))
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// Write writes b once the latency floor is reached.
+func (w flooredWriter) Write(b []byte) (int, error) {
+	time.Sleep(time.Until(w.until))
+	return w.ResponseWriter.Write(b)
+}
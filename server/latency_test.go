@@ -0,0 +1,148 @@
+package server
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const latencySamples = 10000
+
+func TestSampleLatencyNormal(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	params := DistParams{Mean: 20 * time.Millisecond, StdDev: 5 * time.Millisecond}
+
+	var sum, sumSq float64
+	for i := 0; i < latencySamples; i++ {
+		d := float64(sampleLatency("normal", params, rng))
+		sum += d
+		sumSq += d * d
+	}
+
+	mean := sum / latencySamples
+	if math.Abs(mean-float64(params.Mean)) > 0.05*float64(params.Mean) {
+		t.Errorf("expected mean within 5%% of %s; actual: %s", params.Mean, time.Duration(mean))
+	}
+
+	stddev := math.Sqrt(sumSq/latencySamples - mean*mean)
+	if math.Abs(stddev-float64(params.StdDev)) > 0.05*float64(params.StdDev) {
+		t.Errorf("expected stddev within 5%% of %s; actual: %s", params.StdDev, time.Duration(stddev))
+	}
+}
+
+func TestSampleLatencyUniform(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewSource(1))
+	params := DistParams{Min: 5 * time.Millisecond, Max: 50 * time.Millisecond}
+
+	var sum float64
+	for i := 0; i < latencySamples; i++ {
+		d := sampleLatency("uniform", params, rng)
+		if d < params.Min || d > params.Max {
+			t.Fatalf("expected sample in [%s, %s]; actual: %s", params.Min, params.Max, d)
+		}
+		sum += float64(d)
+	}
+
+	expected := float64(params.Min+params.Max) / 2
+	if mean := sum / latencySamples; math.Abs(mean-expected) > 0.05*expected {
+		t.Errorf("expected mean within 5%% of %s; actual: %s", time.Duration(expected), time.Duration(mean))
+	}
+}
+
+func TestCheckDistribution(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		dist   string
+		params DistParams
+		valid  bool
+	}{
+		{"", DistParams{}, true},
+		{"normal", DistParams{Mean: time.Millisecond}, true},
+		{"uniform", DistParams{Min: 2 * time.Millisecond, Max: time.Millisecond}, false},
+		{"gamma", DistParams{}, false},
+	} {
+		err := checkDistribution(c.dist, c.params)
+		if (err == nil) != c.valid {
+			t.Errorf("%q %+v: expected valid=%t; actual error: %v", c.dist, c.params, c.valid, err)
+		}
+	}
+}
+
+func TestAnswerDelayPerType(t *testing.T) {
+	defer func(v typeDelays) { answerDelays = v }(answerDelays)
+	answerDelays = make(typeDelays)
+
+	if err := answerDelays.Set("aaaa:100ms,A:0ms"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"AAAA", "BOGUS:1ms", "AAAA:soon", "AAAA:-1ms"} {
+		if err := make(typeDelays).Set(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+
+	h := logRequest(true, handler(loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}],
+		"aaaa": [{"hostname": "www", "value": "2001:db8::1"}]
+	}}`)["test.com."]))
+
+	elapsed := func(qtype uint16) time.Duration {
+		start := time.Now()
+		query(h, "www.test.com.", qtype)
+		return time.Since(start)
+	}
+
+	a, aaaa := elapsed(dns.TypeA), elapsed(dns.TypeAAAA)
+	if a >= 50*time.Millisecond {
+		t.Errorf("expected A undelayed; elapsed: %s", a)
+	}
+	if aaaa < 100*time.Millisecond {
+		t.Errorf("expected AAAA delayed by 100ms; elapsed: %s", aaaa)
+	}
+
+	// Multiple questions take the longest delay.
+	r := new(dns.Msg)
+	r.Question = []dns.Question{
+		{Name: "www.test.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET},
+		{Name: "www.test.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET},
+	}
+	if d := answerDelays.delay(r); d != 100*time.Millisecond {
+		t.Errorf("expected 100ms for A and AAAA questions; actual: %s", d)
+	}
+}
+
+func TestMinLatency(t *testing.T) {
+	defer func(v time.Duration) { minLatency = v }(minLatency)
+	minLatency = 100 * time.Millisecond
+
+	fast := logRequest(true, handler(loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}]
+	}}`)["test.com."]))
+	slow := logRequest(false, func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(150 * time.Millisecond)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+
+	elapsed := func(h func(dns.ResponseWriter, *dns.Msg)) time.Duration {
+		start := time.Now()
+		query(h, "www.test.com.", dns.TypeA)
+		return time.Since(start)
+	}
+
+	if d := elapsed(fast); d < minLatency {
+		t.Errorf("expected fast query padded to %s; elapsed: %s", minLatency, d)
+	}
+	if d := elapsed(slow); d >= 150*time.Millisecond+minLatency/2 {
+		t.Errorf("expected slow query not delayed further; elapsed: %s", d)
+	}
+}
@@ -0,0 +1,42 @@
+package server_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/awoodbeck/mockdns/server"
+	"github.com/miekg/dns"
+)
+
+// TestImport serves records the way a program importing the package would.
+func TestImport(t *testing.T) {
+	if f := flag.CommandLine.Lookup("data"); f != nil {
+		t.Fatal("expected mockdns's flags off flag.CommandLine")
+	}
+
+	s, err := server.NewServerFromBytes([]byte(`{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	started := make(chan struct{})
+	srv := &dns.Server{PacketConn: pc, Handler: s, NotifyStartedFunc: func() { close(started) }}
+	go srv.ActivateAndServe()
+	<-started
+	defer srv.Shutdown()
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.test.com.", dns.TypeA)
+	m, err := dns.Exchange(r, s.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Fatalf("expected www.test.com. A 10.0.0.1; actual: %v", m.Answer)
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errListenerClosed = errors.New("listener closed")
+
+// limitListener is a net.Listener that limits the number of concurrently
+// active connections. Connections beyond the limit are queued for up to wait
+// before the query they carry is answered with REFUSED and they're closed.
+type limitListener struct {
+	net.Listener
+	sem   chan struct{}
+	wait  time.Duration
+	ready chan net.Conn
+	errc  chan error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newLimitListener(l net.Listener, n int, wait time.Duration) *limitListener {
+	ll := &limitListener{
+		Listener: l,
+		sem:      make(chan struct{}, n),
+		wait:     wait,
+		ready:    make(chan net.Conn),
+		errc:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	go ll.acceptLoop()
+
+	return ll
+}
+
+func (l *limitListener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errc <- err:
+			case <-l.done:
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return
+		}
+		go l.admit(c)
+	}
+}
+
+// admit waits for a free connection slot for c, refusing it if none frees up
+// within the listener's wait duration.
+func (l *limitListener) admit(c net.Conn) {
+	select {
+	case l.sem <- struct{}{}:
+	default:
+		connectionQueueDepth.Add(1)
+		t := time.NewTimer(l.wait)
+		select {
+		case l.sem <- struct{}{}:
+			t.Stop()
+			connectionQueueDepth.Add(-1)
+		case <-t.C:
+			connectionQueueDepth.Add(-1)
+			refuseConn(c)
+			return
+		case <-l.done:
+			t.Stop()
+			connectionQueueDepth.Add(-1)
+			c.Close()
+			return
+		}
+	}
+
+	activeConnections.Add(1)
+	lc := &limitConn{Conn: c, release: l.release}
+
+	select {
+	case l.ready <- lc:
+	case <-l.done:
+		lc.Close()
+	}
+}
+
+func (l *limitListener) release() {
+	activeConnections.Add(-1)
+	<-l.sem
+}
+
+// Accept returns the next admitted connection.
+func (l *limitListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.ready:
+		return c, nil
+	case err := <-l.errc:
+		return nil, err
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close stops accepting connections and closes the underlying listener.
+func (l *limitListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+
+	return l.Listener.Close()
+}
+
+// limitConn releases its listener slot when closed.
+type limitConn struct {
+	net.Conn
+	release func()
+	once    sync.Once
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.release)
+
+	return c.Conn.Close()
+}
+
+// refuseConn answers the first query read from c with REFUSED and closes it.
+func refuseConn(c net.Conn) {
+	defer c.Close()
+
+	err := c.SetDeadline(time.Now().Add(2 * time.Second))
+	if err != nil {
+		return
+	}
+
+	conn := &dns.Conn{Conn: c}
+	r, err := conn.ReadMsg()
+	if err != nil {
+		return
+	}
+
+	m := new(dns.Msg)
+	m.SetRcode(r, dns.RcodeRefused)
+	conn.WriteMsg(m)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLimitListenerRefusesQueuedConnections(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := newLimitListener(inner, 1, 50*time.Millisecond)
+	defer l.Close()
+
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+
+	first, err := net.Dial("tcp", inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	select {
+	case c := <-accepted:
+		defer c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for first connection")
+	}
+
+	client := &dns.Client{Net: "tcp", Timeout: time.Second}
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+
+	m, _, err := client.Exchange(r, inner.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("expected second connection to be refused, not accepted")
+	default:
+	}
+}
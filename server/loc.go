@@ -0,0 +1,63 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// locValue builds LOC record data (RFC 1876) from the decimal-degree lat and
+// lon fields of m, along with its optional altitude, size, hp and vp fields
+// in meters.
+func locValue(m map[string]string) (string, error) {
+	lat, err := dms(m[keyLat], 90, "N", "S")
+	if err != nil {
+		return "", fmt.Errorf("invalid latitude: %s", err)
+	}
+	lon, err := dms(m[keyLon], 180, "E", "W")
+	if err != nil {
+		return "", fmt.Errorf("invalid longitude: %s", err)
+	}
+
+	parts := []string{lat, lon}
+	for _, f := range []struct{ key, def string }{
+		{keyAltitude, "0"},
+		{keySize, "1"},
+		{keyHP, "10000"},
+		{keyVP, "10"},
+	} {
+		v, ok := m[f.key]
+		if !ok {
+			v = f.def
+		}
+		parts = append(parts, strings.TrimSuffix(v, "m")+"m")
+	}
+
+	return strings.Join(parts, " "), nil
+}
+
+// dms converts decimal degrees, bounded by max, to "d m s hemisphere"
+// format, using pos for positive values and neg for negative.
+func dms(decimal string, max float64, pos, neg string) (string, error) {
+	deg, err := strconv.ParseFloat(decimal, 64)
+	if err != nil {
+		return "", err
+	}
+	if math.IsNaN(deg) || deg < -max || deg > max {
+		return "", fmt.Errorf("%q out of range", decimal)
+	}
+
+	hemisphere := pos
+	if deg < 0 {
+		hemisphere = neg
+		deg = -deg
+	}
+
+	// Work in thousandths of a second, LOC's resolution, so rounding never
+	// yields 60 seconds or minutes.
+	ms := int64(math.Round(deg * 3600000))
+
+	return fmt.Sprintf("%d %d %d.%03d %s", ms/3600000, ms%3600000/60000,
+		ms%60000/1000, ms%1000, hemisphere), nil
+}
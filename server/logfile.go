@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logFile is an io.Writer appending to the file at path that can be reopened
+// after the file is rotated out from under it.
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+
+	return l, l.Reopen()
+}
+
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Write(b)
+}
+
+// Reopen closes the current file, if any, and opens the file at path anew.
+// Writes block until the new file is in place.
+func (l *logFile) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if l.f != nil {
+		err = l.f.Close()
+	}
+	l.f = f
+
+	return err
+}
+
+func (l *logFile) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.f.Close()
+}
+
+// reopenOn reopens the file each time a signal is received on chs until ctx
+// is done.
+func (l *logFile) reopenOn(ctx context.Context, chs <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-chs:
+			err := l.Reopen()
+			if err != nil {
+				// The previous file remains in use.
+				log.Printf("Reopening %q: %s", l.path, err)
+			}
+		}
+	}
+}
+
+// Log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// jsonLogWriter writes each log line to w as a JSON object.
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+func (j jsonLogWriter) Write(b []byte) (int, error) {
+	return len(b), j.writeEntry(strings.TrimSuffix(string(b), "\n"), "")
+}
+
+// writeEntry writes msg to w as a JSON object, with the ID of the request it
+// concerns if there is one.
+func (j jsonLogWriter) writeEntry(msg, requestID string) error {
+	line, err := json.Marshal(struct {
+		Time      string `json:"time"`
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id,omitempty"`
+	}{time.Now().Format(time.RFC3339Nano), msg, requestID})
+	if err != nil {
+		return err
+	}
+
+	_, err = j.w.Write(append(line, '\n'))
+
+	return err
+}
+
+// logRequestID logs a message about the request with the given ID, as a
+// request_id field in JSON logs or appended to the message otherwise.
+func logRequestID(requestID, format string, v ...interface{}) {
+	if j, ok := log.Writer().(jsonLogWriter); ok {
+		err := j.writeEntry(fmt.Sprintf(format, v...), requestID)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	log.Printf(format+" (request ID %s)", append(v, requestID)...)
+}
+
+// parseSignal returns the rotation signal named s, with or without its
+// "SIG" prefix.
+func parseSignal(s string) (os.Signal, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if sig, ok := rotateSignals[name]; ok {
+		return sig, nil
+	}
+
+	return nil, fmt.Errorf("unsupported log rotation signal %q", s)
+}
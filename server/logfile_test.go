@@ -0,0 +1,104 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestLogFileReopenOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "query.log")
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	rotate := make(chan os.Signal, 1)
+	signal.Notify(rotate, syscall.SIGUSR1)
+	defer signal.Stop(rotate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.reopenOn(ctx, rotate)
+
+	_, err = l.Write([]byte("before\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated := path + ".1"
+	err = os.Rename(path, rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes between rotation and the signal land in the rotated file.
+	_, err = l.Write([]byte("between\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes block until the new file is swapped in, so once it exists
+	// further writes go to it.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err = os.Stat(path); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("log file not reopened")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	_, err = l.Write([]byte("after\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{
+		rotated: "before\nbetween\n",
+		path:    "after\n",
+	} {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != expected {
+			t.Errorf("%s: expected %q; actual: %q", filepath.Base(file), expected, b)
+		}
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{"SIGUSR1", "usr1", "USR2"} {
+		if _, err := parseSignal(s); err != nil {
+			t.Errorf("%q: %s", s, err)
+		}
+	}
+	if _, err := parseSignal("SIGKILL"); err == nil {
+		t.Error("expected unsupported signal error")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// rotateSignals are the signals that may trigger log file rotation.
+var rotateSignals = map[string]os.Signal{
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
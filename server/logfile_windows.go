@@ -0,0 +1,7 @@
+package server
+
+import "os"
+
+// rotateSignals are the signals that may trigger log file rotation; Windows
+// has none.
+var rotateSignals = map[string]os.Signal{}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"expvar"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var malformedQueries = expvar.NewInt("mockdns_malformed_queries_total")
+
+// logMalformed logs and counts a malformed query from addr.
+func logMalformed(addr net.Addr, err error) {
+	malformedQueries.Add(1)
+	log.Printf("Malformed query from %s: %s", addr, err)
+}
+
+// receivedReader is a dns.Reader tracking the messages it reads until
+// they're answered, so those the dns package answers with FORMERR without
+// consulting the handler are logged and counted as malformed.
+type receivedReader struct {
+	dns.Reader
+}
+
+// decorateReader wraps r to reject oversized messages, and to track the rest
+// so malformed ones are logged and counted.
+func decorateReader(r dns.Reader) dns.Reader {
+	return receivedReader{payloadReader{Reader: r, max: maxPayload}}
+}
+
+func (r receivedReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	b, err := r.Reader.ReadTCP(conn, timeout)
+	if err == nil {
+		receivedQueries.received(conn.RemoteAddr(), b)
+	}
+
+	return b, err
+}
+
+func (r receivedReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	b, s, err := r.Reader.ReadUDP(conn, timeout)
+	if err == nil {
+		receivedQueries.received(s.RemoteAddr(), b)
+	}
+
+	return b, s, err
+}
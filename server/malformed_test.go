@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
+
+func TestMalformedQueries(t *testing.T) {
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts, _ := NewTestServer(t, d)
+
+	conn, err := net.Dial("udp", ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	b, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	noQuestion := new(dns.Msg)
+	noQuestion.Id = dns.Id()
+	nb, err := noQuestion.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		name   string
+		packet []byte
+		log    string
+	}{
+		{"truncated question", b[:len(b)-3], "Malformed query from"},
+		{"no question", nb, "no question"},
+	} {
+		before := malformedQueries.Value()
+
+		_, err = conn.Write(c.packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		reply := make([]byte, dns.MinMsgSize)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(reply)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		m := new(dns.Msg)
+		err = m.Unpack(reply[:n])
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+
+		if m.Rcode != dns.RcodeFormatError {
+			t.Errorf("%s: expected FORMERR; actual: %s", c.name, dns.RcodeToString[m.Rcode])
+		}
+		if actual := malformedQueries.Value() - before; actual != 1 {
+			t.Errorf("%s: expected 1 counted malformed query; actual: %d", c.name, actual)
+		}
+		if !strings.Contains(buf.String(), c.log) {
+			t.Errorf("%s: expected %q logged; actual: %q", c.name, c.log, buf.String())
+		}
+	}
+}
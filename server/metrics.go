@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	activeConnections    = expvar.NewInt("mockdns_active_connections")
+	connectionQueueDepth = expvar.NewInt("mockdns_connection_queue_depth")
+
+	queryDuration = newHistogram("mockdns_query_duration_seconds",
+		0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5)
+	responseSize = newHistogram("mockdns_response_size_bytes",
+		64, 128, 256, 512, 1024, 2048, 4096, 8192, 16384, 65535)
+
+	// Reloads replace the records but never reset these metrics; only a
+	// restart does, which a new start time shows.
+	startTime = time.Now()
+	reloads   = expvar.NewInt("mockdns_reloads_total")
+
+	proxyRetries = expvar.NewInt("mockdns_proxy_retries_total")
+
+	// parseErrors counts the data file entries skipped under
+	// -no-fatal-parse by domain.
+	parseErrors = newCounterVec("mockdns_parse_errors_total", "domain")
+)
+
+func init() {
+	expvar.NewInt("mockdns_start_time_seconds").Set(startTime.Unix())
+	expvar.Publish("mockdns_uptime_seconds", expvar.Func(func() interface{} {
+		return time.Since(startTime).Seconds()
+	}))
+}
+
+// counterVec is an expvar.Map of counters, exposed to Prometheus as a single
+// metric with each key as the value of label.
+type counterVec struct {
+	expvar.Map
+	label string
+}
+
+// newCounterVec creates and publishes a counterVec.
+func newCounterVec(name, label string) *counterVec {
+	cv := &counterVec{label: label}
+	cv.Init()
+	expvar.Publish(name, cv)
+
+	return cv
+}
+
+// writePrometheus writes the counters named name in the Prometheus text
+// exposition format.
+func (cv *counterVec) writePrometheus(w io.Writer, name string) {
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	cv.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%s{%s=%q} %s\n", name, cv.label, kv.Key, kv.Value)
+	})
+}
+
+// histogram is an expvar.Var counting observations in cumulative buckets.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// newHistogram creates and publishes a histogram with the given bucket upper
+// bounds, which must be in increasing order.
+func newHistogram(name string, bounds ...float64) *histogram {
+	h := &histogram{bounds: bounds, buckets: make([]uint64, len(bounds))}
+	expvar.Publish(name, h)
+
+	return h
+}
+
+// Observe adds v to the histogram.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range h.bounds {
+		if v <= b {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// String returns the histogram as JSON, satisfying expvar.Var.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	buckets := make([]string, len(h.bounds))
+	for i, b := range h.bounds {
+		buckets[i] = fmt.Sprintf("%q: %d", formatFloat(b), h.buckets[i])
+	}
+
+	return fmt.Sprintf(`{"buckets": {%s}, "count": %d, "sum": %s}`,
+		strings.Join(buckets, ", "), h.count, formatFloat(h.sum))
+}
+
+// writePrometheus writes the histogram named name in the Prometheus text
+// exposition format.
+func (h *histogram) writePrometheus(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, b := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(b), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// metricsHandler serves the expvar metrics as JSON at /debug/vars and the
+// mockdns metrics in the Prometheus text exposition format at /metrics.
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w)
+	})
+
+	return mux
+}
+
+// writePrometheus writes every published mockdns_ metric to w.
+func writePrometheus(w io.Writer) {
+	vars := make(map[string]expvar.Var)
+	var names []string
+	expvar.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "mockdns_") {
+			vars[kv.Key] = kv.Value
+			names = append(names, kv.Key)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		switch v := vars[name].(type) {
+		case *histogram:
+			v.writePrometheus(w, name)
+		case *counterVec:
+			v.writePrometheus(w, name)
+		case *expvar.Int:
+			typ := "gauge"
+			if strings.HasSuffix(name, "_total") {
+				typ = "counter"
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", name, typ, name, v.Value())
+		case expvar.Func:
+			if f, ok := v.Value().(float64); ok {
+				fmt.Fprintf(w, "# TYPE %s gauge\n%s %s\n", name, name, formatFloat(f))
+			}
+		}
+	}
+}
+
+// serveMetrics exposes the metrics over HTTP on addr until ctx is canceled.
+func serveMetrics(ctx context.Context, addr string) {
+	server := &http.Server{Addr: addr, Handler: metricsHandler()}
+
+	go func() {
+		<-ctx.Done()
+		err := server.Shutdown(context.Background())
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	log.Printf("Metrics listening on %s ...\n", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	log.Printf("%s metrics listener stopped\n", addr)
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// scrapeMetrics fetches the Prometheus metrics and returns the sample values
+// keyed by series name.
+func scrapeMetrics(t *testing.T) map[string]float64 {
+	t.Helper()
+
+	ts := httptest.NewServer(metricsHandler())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200; actual: %d", resp.StatusCode)
+	}
+
+	samples := make(map[string]float64)
+	s := bufio.NewScanner(resp.Body)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "#") {
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			t.Fatalf("malformed sample %q", s.Text())
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samples[fields[0]] = v
+	}
+
+	return samples
+}
+
+func TestMetricsHistograms(t *testing.T) {
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	query(logRequest(true, handler(d["test.com."])), "test.com.", dns.TypeA)
+
+	samples := scrapeMetrics(t)
+	for _, name := range []string{
+		"mockdns_query_duration_seconds_count",
+		"mockdns_response_size_bytes_count",
+		`mockdns_response_size_bytes_bucket{le="+Inf"}`,
+	} {
+		if samples[name] < 1 {
+			t.Errorf("expected observations for %s; actual: %v", name, samples[name])
+		}
+	}
+}
+
+func TestMetricsPersistAcrossReload(t *testing.T) {
+	s := NewServer(loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`))
+	query(s.ServeDNS, "test.com.", dns.TypeA)
+
+	before := scrapeMetrics(t)
+	s.Reload(loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.2"}]}}`))
+	after := scrapeMetrics(t)
+
+	if b, a := before["mockdns_reloads_total"], after["mockdns_reloads_total"]; a != b+1 {
+		t.Errorf("expected reloads to go from %v to %v; actual: %v", b, b+1, a)
+	}
+	for _, name := range []string{"mockdns_query_duration_seconds_count", "mockdns_response_size_bytes_count"} {
+		if b, a := before[name], after[name]; b == 0 || a < b {
+			t.Errorf("expected %s to persist across the reload; before: %v, after: %v", name, b, a)
+		}
+	}
+	if start := after["mockdns_start_time_seconds"]; start != float64(startTime.Unix()) {
+		t.Errorf("expected start time %d; actual: %v", startTime.Unix(), start)
+	}
+	if after["mockdns_uptime_seconds"] <= 0 {
+		t.Errorf("expected positive uptime; actual: %v", after["mockdns_uptime_seconds"])
+	}
+}
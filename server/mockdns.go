@@ -0,0 +1,1173 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/miekg/dns"
+)
+
+const (
+	keyAlgorithm     = "algorithm"
+	keyAltitude      = "altitude"
+	keyAvailability  = "availability"
+	keyCertType      = "cert_type"
+	keyDeny          = "deny"
+	keyDenyAfter     = "deny_after"
+	keyDenyUntil     = "deny_until"
+	keyFlags         = "flags"
+	keyHostname      = "hostname"
+	keyHP            = "hp"
+	keyKeyTag        = "key_tag"
+	keyLat           = "lat"
+	keyLon           = "lon"
+	keyMatchingType  = "matching_type"
+	keyPriority      = "priority"
+	keySelector      = "selector"
+	keySerial        = "serial"
+	keySize          = "size"
+	keyTTL           = "ttl"
+	keyTTLAdditional = "ttl_additional"
+	keyTTLAuthority  = "ttl_authority"
+	keyTypeBitmap    = "type_bitmap"
+	keyType          = "type"
+	keyUsage         = "usage"
+	keyValue         = "value"
+	keyVP            = "vp"
+	keyWeight        = "weight"
+)
+
+var (
+	addr,
+	configFile,
+	dataFile,
+	defaultTTL,
+	logFilePath,
+	logRotateSignal,
+	logFormat,
+	dotAddr,
+	dohAddr,
+	requestIDHeader,
+	tlsCert,
+	tlsKey,
+	tlsClientCA,
+	otlpEndpoint,
+	metricsAddr,
+	profileAddr,
+	proxyDoH,
+	captureFile,
+	answerFromFile,
+	answerOrder,
+	captureFormat,
+	upstreamStrategy,
+	proxyOrder,
+	cookieSecret,
+	delayDist,
+	stateFile,
+	zoneSerial,
+	resolvConfFile,
+	serverID,
+	nsid,
+	versionString string
+	maxConnections,
+	maxPayload,
+	maxAnswers,
+	padTo,
+	proxyConcurrency int
+	maxConnectionWait,
+	minLatency,
+	minTTL,
+	maxTTL,
+	proxyTimeout,
+	proxyServerTimeout,
+	proxyTotalTimeout,
+	queryTimeout,
+	proxyRetryInitialDelay,
+	proxyRetryMaxDelay,
+	upstreamHealthInterval,
+	startupDelay time.Duration
+	chaos,
+	aaaaServfail,
+	coalesceLocal,
+	debugExtra,
+	dryRun,
+	cookieEnforce,
+	localOnly,
+	mxRandomizeEqual,
+	noFatalParse,
+	noProxyOnError,
+	tlsClientCAOptional,
+	preferIPv6,
+	preserveCase,
+	printCfg,
+	proxy,
+	refuseMultiQ,
+	serveStale,
+	stripDNSSECRRs,
+	strict,
+	verbose,
+	zonemd bool
+	seed               int64
+	readyAt            time.Time
+	delayParams        DistParams
+	views              = make(viewFiles)
+	sourcePortViews    = make(portViews)
+	rewrites           rewriteRules
+	staleAnswers       staleCache
+	replay             replayStore
+	typeConfuse        = make(typeConfusions)
+	answerDelays       = make(typeDelays)
+	client             *dns.Client
+	clientConfig       *dns.ClientConfig
+	doh                *dohClient
+	proxyFlights       flightGroup
+	picker             ResolverPicker
+	upstreamHealth     *healthChecker
+	proxySem           chan struct{}
+	proxyAfter         = func(_ proxyTimer, d time.Duration) <-chan time.Time { return time.After(d) } // times proxyExchange's total timeout and retries
+	dotConfig          *tls.Config
+	zoneSerials        = newSerialState("")
+	capture            *queryCapture
+	errNilMapUnmarshal = errors.New("cannot unmarshal into nil map")
+
+	supportedTypes = map[string]uint16{
+		"A":          dns.TypeA,
+		"AAAA":       dns.TypeAAAA,
+		"CAA":        dns.TypeCAA,
+		"CERT":       dns.TypeCERT,
+		"CNAME":      dns.TypeCNAME,
+		"CSYNC":      dns.TypeCSYNC,
+		"EUI48":      dns.TypeEUI48,
+		"EUI64":      dns.TypeEUI64,
+		"LOC":        dns.TypeLOC,
+		"MX":         dns.TypeMX,
+		"NS":         dns.TypeNS,
+		"OPENPGPKEY": dns.TypeOPENPGPKEY,
+		"PTR":        dns.TypePTR,
+		"SMIMEA":     dns.TypeSMIMEA,
+		"SOA":        dns.TypeSOA,
+		"TXT":        dns.TypeTXT,
+		"ZONEMD":     typeZONEMD,
+	}
+
+	// ttlUnits maps TTL duration units to seconds.
+	ttlUnits = map[rune]uint64{
+		'w': 7 * 24 * 60 * 60,
+		'd': 24 * 60 * 60,
+		'h': 60 * 60,
+		'm': 60,
+		's': 1,
+	}
+
+	// typeAliases maps alternate type names to their supported equivalent.
+	typeAliases = map[string]string{
+		"A6":    "AAAA",
+		"ANAME": "ALIAS",
+		"SPF":   "TXT",
+	}
+
+	rngMu sync.Mutex
+	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	cFailure  = color.New(color.FgRed).Sprint("F")
+	cSuccess  = color.New(color.FgGreen).Sprint("S")
+	cOverride = color.New(color.FgYellow).Sprint("O")
+	cProxied  = color.New(color.FgBlue).Sprint("P")
+	cRefused  = color.New(color.FgMagenta).Sprint("R")
+	cTerminal = color.New(color.FgRed).Sprint("T")
+
+	handlerColors = map[string]string{
+		"local":    cOverride,
+		"refused":  cRefused,
+		"proxied":  cProxied,
+		"terminal": cTerminal,
+	}
+)
+
+// flags holds the command line flags, kept off flag.CommandLine so programs
+// importing this package keep their own.
+var flags = flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+
+func init() {
+	flags.StringVar(&addr, "addr", "127.0.0.1:8053", "default listening address")
+	flags.StringVar(&configFile, "config", "", "TOML configuration file (command line flags take precedence)")
+	flags.StringVar(&dataFile, "data", "", `DNS record data file ("-" reads stdin)`)
+	flags.StringVar(&defaultTTL, "ttl", "3600", "default TTL in seconds or as a duration (e.g., 1h)")
+	flags.StringVar(&resolvConfFile, "resolv", "/etc/resolv.conf", "resolv.conf file path")
+	flags.Var(views, "view", "named view data file as name=path (repeatable)")
+	flags.Var(sourcePortViews, "source-port-map", "comma-separated client source port:view routes")
+	flags.Var(typeConfuse, "type-confuse", "answer queries for a name and type with the name's records of another type, as NAME:TYPE=TYPE, e.g. www.example.com:A=TXT (repeatable)")
+	flags.Var(&rewrites, "rewrite", "answer rewrite rule as TYPE:from=to, e.g. A:10.0.0.1=10.0.0.2 (repeatable)")
+	flags.StringVar(&metricsAddr, "metrics-addr", "", "metrics HTTP listening address (disabled if empty)")
+	flags.StringVar(&profileAddr, "profile-addr", "", "pprof HTTP listening address; unauthenticated, so bind to loopback only (disabled if empty)")
+	flags.StringVar(&answerFromFile, "answer-from-file", "", "directory of captured wire-format responses to serve verbatim (disabled if empty)")
+	flags.StringVar(&captureFile, "query-capture-file", "", "file to capture incoming queries to (disabled if empty)")
+	flags.StringVar(&captureFormat, "query-capture-format", "qlog", "query capture file format: qlog or pcapng")
+	flags.IntVar(&padTo, "pad-to", 0, "pad EDNS0 responses to this many bytes (0 = disabled)")
+	flags.IntVar(&maxPayload, "max-payload", dns.MaxMsgSize, "drop UDP messages and close TCP connections carrying messages larger than this many bytes")
+	flags.IntVar(&maxConnections, "max-connections", 0, "maximum concurrent TCP connections (0 = unlimited)")
+	flags.DurationVar(&maxConnectionWait, "max-connection-wait", time.Second, "how long excess TCP connections queue before being refused")
+	flags.BoolVar(&coalesceLocal, "coalesce-local", false, "answer identical concurrent local queries with a single lookup (they share one availability roll, answer shuffle and deny counter increment)")
+	flags.BoolVar(&chaos, "chaos", false, "answer CHAOS-class version.bind and id.server queries")
+	flags.StringVar(&versionString, "version-string", "mockdns", "version.bind TXT value")
+	flags.StringVar(&serverID, "server-id", "mockdns", "id.server TXT value")
+	flags.BoolVar(&debugExtra, "debug-extra", false, "add a TXT record naming the matched domain, reply source and server ID to the additional section")
+	flags.StringVar(&nsid, "nsid", "", "identifier returned in the EDNS0 NSID option when requested (disabled if empty)")
+	flags.BoolVar(&proxy, "proxy", true, "proxy unmatched requests to root name servers")
+	flags.StringVar(&proxyDoH, "proxy-upstream-doh", "", "DNS-over-HTTPS URL to proxy unmatched requests to instead of resolv.conf servers")
+	flags.DurationVar(&proxyTimeout, "proxy-timeout", 2*time.Second, "proxied request timeout")
+	flags.DurationVar(&proxyServerTimeout, "proxy-server-timeout", 0, "timeout per upstream server attempt (0 = -proxy-timeout)")
+	flags.DurationVar(&proxyRetryInitialDelay, "proxy-retry-initial-delay", 50*time.Millisecond, "backoff before the first retry of failed proxied requests within -proxy-total-timeout")
+	flags.DurationVar(&proxyRetryMaxDelay, "proxy-retry-max-delay", time.Second, "maximum backoff between retries of failed proxied requests")
+	flags.DurationVar(&upstreamHealthInterval, "upstream-health-interval", 0, "probe upstream servers this often and skip those not answering (0 = disabled)")
+	flags.DurationVar(&proxyTotalTimeout, "proxy-total-timeout", 0, "timeout for all upstream server attempts combined (0 = unlimited)")
+	flags.BoolVar(&stripDNSSECRRs, "strip-dnssec", false, "remove DNSSEC records from proxied responses and clear their AD bit")
+	flags.DurationVar(&queryTimeout, "query-timeout", 10*time.Second, "answer SERVFAIL to queries not answered within this duration (0 = unlimited)")
+	flags.BoolVar(&serveStale, "serve-stale", false, "answer with the last proxied reply, its TTLs capped at 30s, when every upstream fails (RFC 8767)")
+	flags.IntVar(&proxyConcurrency, "proxy-concurrency", 0, "maximum concurrent upstream exchanges (0 = unlimited)")
+	flags.BoolVar(&localOnly, "serve-local-only", false, "refuse unmatched requests instead of proxying them")
+	flags.BoolVar(&aaaaServfail, "aaaa-servfail", false, "answer local AAAA queries with SERVFAIL")
+	flags.BoolVar(&cookieEnforce, "cookie-enforce", false, "answer BADCOOKIE to requests with an invalid server cookie")
+	flags.BoolVar(&tlsClientCAOptional, "tls-client-ca-optional", false, "only verify DNS-over-TLS client certificates when presented")
+	flags.BoolVar(&mxRandomizeEqual, "mx-randomize-equal", false, "shuffle MX answers of equal preference")
+	flags.BoolVar(&preferIPv6, "prefer-ipv6", false, "list AAAA answers before A answers")
+	flags.BoolVar(&preserveCase, "preserve-case", false, "keep the case of domain names in the data file instead of lowercasing them")
+	flags.BoolVar(&refuseMultiQ, "refuse-multi-question", false, "refuse queries with more than one question")
+	flags.BoolVar(&noFatalParse, "no-fatal-parse", false, "skip invalid records and domains in the data file instead of exiting")
+	flags.BoolVar(&noProxyOnError, "no-proxy-on-error", false, "answer SERVFAIL instead of proxying or negative answers for domains with records skipped by -no-fatal-parse")
+	flags.BoolVar(&strict, "strict", false, "treat data file validation warnings as errors")
+	flags.BoolVar(&verbose, "v", true, "verbose output")
+	flags.BoolVar(&dryRun, "dry-run", false, "validate the data file, print a summary and exit")
+	flags.BoolVar(&printCfg, "print-config", false, "print the effective configuration as JSON and exit")
+	flags.DurationVar(&minTTL, "min-ttl", 0, "raise local answer TTLs to at least this duration")
+	flags.DurationVar(&maxTTL, "max-ttl", 0, "lower local answer TTLs to at most this duration (0 = unlimited)")
+	flags.DurationVar(&startupDelay, "startup-delay", 0, "answer SERVFAIL for this long after the listeners start")
+	flags.DurationVar(&minLatency, "min-latency", 0, "delay every reply until at least this long after its request was received")
+	flags.Var(answerDelays, "answer-delay-per-type", "extra response delay by query type as TYPE:duration pairs, e.g. AAAA:50ms,A:0ms")
+	flags.StringVar(&delayDist, "response-delay-distribution", "", "response delay distribution: normal or uniform (disabled if empty)")
+	flags.DurationVar(&delayParams.Mean, "delay-mean", 0, "normal response delay mean")
+	flags.DurationVar(&delayParams.StdDev, "delay-stddev", 0, "normal response delay standard deviation")
+	flags.DurationVar(&delayParams.Min, "delay-min", 0, "uniform response delay minimum")
+	flags.DurationVar(&delayParams.Max, "delay-max", 0, "uniform response delay maximum")
+	flags.StringVar(&answerOrder, "order", orderStable, "answer ordering: stable, random or weighted")
+	flags.IntVar(&maxAnswers, "max-answers", 0, "answer with at most this many local records of each type, after -order (0 = unlimited)")
+	flags.StringVar(&upstreamStrategy, "upstream-retry-strategy", strategyFirst, "upstream server tried first: first, round-robin, random or least-latency")
+	flags.StringVar(&proxyOrder, "proxy-order", "", "alias setting -upstream-retry-strategy from an upstream server ordering: sequential (first), random or fastest (least-latency)")
+	flags.StringVar(&logFilePath, "log-file", "", "append logs to this file instead of stderr")
+	flags.StringVar(&logFormat, "log-format", logFormatText, "log and -dry-run output format: text or json")
+	flags.StringVar(&logRotateSignal, "query-log-rotate-signal", "SIGUSR1", "signal reopening -log-file after rotation")
+	flags.StringVar(&dotAddr, "tls-addr", "", "DNS-over-TLS listen address (disabled if empty)")
+	flags.StringVar(&dohAddr, "doh-addr", "", "DNS-over-HTTPS listen address, serving HTTPS with -tls-cert and -tls-key if set or else plain HTTP (disabled if empty)")
+	flags.StringVar(&requestIDHeader, "request-id-header", "X-Request-ID", "DNS-over-HTTPS request header whose value, or a generated UUID if absent, identifies the query in logs and spans")
+	flags.StringVar(&tlsCert, "tls-cert", "", "DNS-over-TLS certificate file")
+	flags.StringVar(&tlsKey, "tls-key", "", "DNS-over-TLS private key file")
+	flags.StringVar(&tlsClientCA, "tls-client-ca", "", "CA certificate file DNS-over-TLS clients must present certificates signed by")
+	flags.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP traces endpoint receiving a span per query, e.g. http://localhost:4318/v1/traces")
+	flags.StringVar(&cookieSecret, "cookie-secret", "", "secret for deriving DNS server cookies (RFC 7873); enables cookies")
+	flags.BoolVar(&zonemd, "zonemd", false, "add a SHA-384 ZONEMD record (RFC 8976) to the apex of every zone with an SOA record")
+	flags.StringVar(&zoneSerial, "zone-serial", "", `SOA serial policy: "auto" assigns every SOA serial (default as specified)`)
+	flags.StringVar(&stateFile, "state-file", "", "file persisting auto SOA serials across restarts")
+	flags.Int64Var(&seed, "seed", 0, "random number generator seed (0 = time-based)")
+}
+
+// Main runs mockdns as configured by the command line flags in os.Args.
+func Main() {
+	flags.Parse(os.Args[1:])
+
+	if configFile != "" {
+		err := loadConfigFile(flags, configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if flags.Arg(0) == "config-dump" {
+		err := dumpConfig(os.Stdout, flags)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var lf *logFile
+	if logFilePath != "" {
+		var err error
+		lf, err = openLogFile(logFilePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.SetOutput(lf)
+	}
+	switch logFormat {
+	case logFormatText:
+	case logFormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{log.Writer()})
+	default:
+		log.Fatalf("Unknown log format %q", logFormat)
+	}
+
+	if dataFile == "" {
+		log.Fatal("Data file required")
+	}
+
+	if seed != 0 {
+		seedRNG(seed)
+	}
+
+	err := checkDistribution(delayDist, delayParams)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = checkOrder(answerOrder)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if proxyOrder != "" {
+		upstreamStrategy, err = proxyOrderStrategy(proxyOrder)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if maxTTL > 0 && minTTL > maxTTL {
+		log.Fatalf("-min-ttl %s exceeds -max-ttl %s", minTTL, maxTTL)
+	}
+	if maxPayload < dnsHeaderSize || maxPayload > dns.MaxMsgSize {
+		log.Fatalf("-max-payload must be between %d and %d", dnsHeaderSize, dns.MaxMsgSize)
+	}
+
+	// A dry run only needs the data file, not the proxy, TLS or serial state.
+	d, err := loadDataFile(dataFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if dryRun {
+		err = printSummary(os.Stdout, summarize(d, dryRunWarnings), logFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	err = setupProxy()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if dotAddr != "" || dohAddr != "" && tlsCert != "" {
+		dotConfig, err = dotTLSConfig(tlsCert, tlsKey, tlsClientCA, tlsClientCAOptional)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if zoneSerial != "" && zoneSerial != "auto" {
+		log.Fatalf("Unknown zone serial policy %q", zoneSerial)
+	}
+	zoneSerials, err = loadSerialState(stateFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if answerFromFile != "" {
+		replay, err = loadReplay(answerFromFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	srv := NewServer(d)
+	for name, path := range views {
+		if path == "-" && dataFile == "-" {
+			log.Fatalf("View %q and -data cannot both read stdin", name)
+		}
+		vd, vErr := loadDataFile(path)
+		if vErr != nil {
+			log.Fatal(vErr)
+		}
+		srv.AddView(name, vd)
+	}
+	for port, view := range sourcePortViews {
+		err = srv.RouteSourcePort(port, view)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if printCfg {
+		err = printConfig(os.Stdout, d)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if captureFile != "" {
+		capture, err = newQueryCapture(captureFile, captureFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	readyAt = time.Now().Add(startupDelay)
+
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if lf != nil {
+		sig, sErr := parseSignal(logRotateSignal)
+		if sErr != nil {
+			log.Fatal(sErr)
+		}
+		rotate := make(chan os.Signal, 1)
+		signal.Notify(rotate, sig)
+		wg.Add(1)
+		go func() {
+			lf.reopenOn(ctx, rotate)
+			wg.Done()
+		}()
+	}
+
+	if otlpEndpoint != "" {
+		exp := newOTLPExporter(otlpEndpoint)
+		tracer = exp
+		wg.Add(1)
+		go func() {
+			exp.run(ctx)
+			wg.Done()
+		}()
+	}
+
+	if upstreamHealth != nil {
+		wg.Add(1)
+		go func() {
+			upstreamHealth.run(ctx, upstreamAddrs(clientConfig), upstreamHealthInterval)
+			wg.Done()
+		}()
+	}
+
+	if metricsAddr != "" {
+		wg.Add(1)
+		go func() {
+			serveMetrics(ctx, metricsAddr)
+			wg.Done()
+		}()
+	}
+
+	if profileAddr != "" {
+		wg.Add(1)
+		go func() {
+			serveProfile(ctx, profileAddr)
+			wg.Done()
+		}()
+	}
+
+	pc, l, err := srv.Listen(addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Scripts discover an ephemeral port (addr with port 0) from this line.
+	fmt.Printf("MOCKDNS_ADDR=%s\n", srv.Addr())
+
+	for _, server := range []*dns.Server{
+		{Addr: srv.Addr(), Net: "udp", PacketConn: pc},
+		{Addr: srv.Addr(), Net: "tcp", Listener: l},
+	} {
+		wg.Add(1)
+		go func(server *dns.Server) {
+			serve(ctx, server, srv)
+			wg.Done()
+		}(server)
+	}
+
+	if dotAddr != "" {
+		wg.Add(1)
+		go func() {
+			serve(ctx, &dns.Server{Addr: dotAddr, Net: "tcp-tls"}, srv)
+			wg.Done()
+		}()
+	}
+
+	if dohAddr != "" {
+		var cfg *tls.Config
+		if tlsCert != "" {
+			cfg = dotConfig
+		}
+		wg.Add(1)
+		go func() {
+			serveDoH(ctx, dohAddr, srv, cfg)
+			wg.Done()
+		}()
+	}
+
+	chs := make(chan os.Signal, 1)
+	signal.Notify(chs, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	s := <-chs
+	for ; s == syscall.SIGHUP; s = <-chs {
+		if dataFile == "-" {
+			log.Printf("Received %q signal; cannot reload data read from stdin", s)
+			continue
+		}
+		log.Printf("Received %q signal; reloading %q ...\n", s, dataFile)
+		rd, rErr := loadDataFile(dataFile)
+		if rErr != nil {
+			log.Printf("Reloading %q: %s", dataFile, rErr)
+			continue
+		}
+		srv.Reload(rd)
+	}
+	fmt.Println()
+	log.Printf("Received %q signal; stopping ...\n", s)
+	cancel()
+	wg.Wait()
+
+	if capture != nil {
+		err = capture.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	if lf != nil {
+		log.SetOutput(os.Stderr)
+		if logFormat == logFormatJSON {
+			log.SetOutput(jsonLogWriter{os.Stderr})
+		}
+		err = lf.Close()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// setupProxy configures the DoH or resolv.conf upstreams, client, picker and
+// health checker used to proxy unmatched requests, as set by the flags.
+func setupProxy() error {
+	var err error
+	switch {
+	case !proxy || localOnly:
+		// unmatched requests are never proxied
+	case proxyDoH != "":
+		doh = newDoHClient(proxyDoH, proxyTimeout)
+	default:
+		clientConfig, err = dns.ClientConfigFromFile(resolvConfFile)
+		if err != nil {
+			return fmt.Errorf("reading %q: %s", resolvConfFile, err)
+		}
+		if len(clientConfig.Servers) == 0 {
+			return fmt.Errorf("no name servers found in %q", resolvConfFile)
+		}
+		picker, err = newResolverPicker(upstreamStrategy, upstreamAddrs(clientConfig))
+		if err != nil {
+			return err
+		}
+		client = &dns.Client{Timeout: proxyTimeout}
+		if proxyServerTimeout > 0 {
+			client = &dns.Client{
+				DialTimeout:  proxyServerTimeout,
+				ReadTimeout:  proxyServerTimeout,
+				WriteTimeout: proxyServerTimeout,
+			}
+		}
+		// The probe exchanges with the client, so it must exist first.
+		if upstreamHealthInterval > 0 {
+			upstreamHealth = newHealthChecker(exchangeProbe(client))
+		}
+	}
+	if proxyConcurrency > 0 {
+		proxySem = make(chan struct{}, proxyConcurrency)
+	}
+
+	return nil
+}
+
+// serve serves h on server until ctx is done. The server listens on its Addr
+// unless it's given a bound PacketConn or Listener.
+func serve(ctx context.Context, server *dns.Server, h dns.Handler) {
+	server.Handler = h
+	server.TLSConfig = dotConfig
+	server.DecorateReader = decorateReader
+	server.DecorateWriter = decorateWriter
+	server.UDPSize = udpBufferSize(maxPayload)
+	addr, net := server.Addr, server.Net
+
+	go func() {
+		<-ctx.Done()
+		err := server.Shutdown()
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	var err error
+	log.Printf("Listening on %s/%s ...\n", addr, net)
+	switch {
+	case server.Listener != nil && maxConnections > 0:
+		server.Listener = newLimitListener(server.Listener, maxConnections, maxConnectionWait)
+		err = server.ActivateAndServe()
+	case server.PacketConn != nil, server.Listener != nil:
+		err = server.ActivateAndServe()
+	default:
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		log.Println(err)
+	}
+	log.Printf("%s/%s listener stopped\n", addr, net)
+}
+
+// openDataFile opens the DNS record data file at path, or stdin if path is
+// "-".
+func openDataFile(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+
+	return os.Open(path)
+}
+
+// loadDataFile reads the DNS record data file at path.
+func loadDataFile(path string) (data, error) {
+	f, err := openDataFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return loadData(f)
+}
+
+// loadData reads DNS record data from r.
+func loadData(r io.Reader) (data, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	d := make(data)
+	err = json.Unmarshal(b, &d)
+
+	return d, err
+}
+
+// registerHandlers adds a handler for each domain in d, dispatching queries
+// by type to any "domain/TYPE" records, the CHAOS handlers if enabled, and
+// the proxy handler for everything else, to mux.
+func registerHandlers(mux *dns.ServeMux, d data) {
+	for domain, recs := range d {
+		recs.zones = d
+		h := logRequest(true, replay.wrap(handler(recs)))
+		if recs.typedOnly {
+			h = logRequest(false, replay.wrap(proxyHandler))
+		}
+
+		if len(recs.byType) > 0 {
+			handlers := make(map[uint16]func(dns.ResponseWriter, *dns.Msg), len(recs.byType))
+			for qtype, typed := range recs.byType {
+				typed.zones = d
+				handlers[qtype] = logRequest(true, replay.wrap(handler(typed)))
+			}
+			h = typeHandler(handlers, h)
+		}
+
+		// The mux lowercases query names before matching them.
+		mux.HandleFunc(strings.ToLower(domain), h)
+	}
+
+	if chaos {
+		registerChaosHandlers(mux)
+	}
+
+	mux.HandleFunc(".", logRequest(false, replay.wrap(proxyHandler)))
+}
+
+func handler(recs records) func(dns.ResponseWriter, *dns.Msg) {
+	var flights flightGroup
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		var m *dns.Msg
+		if coalesceLocal {
+			key := dns.OpcodeToString[r.Opcode] + "/" + flightKey(r)
+			m, _, _ = flights.Do(key, func() (*dns.Msg, string, error) {
+				return recs.reply(r), "", nil
+			})
+
+			// The reply is shared with concurrent identical requests, which
+			// may differ in ID, flags and question case.
+			m = m.Copy()
+			m.Id = r.Id
+			m.RecursionDesired = r.RecursionDesired
+			m.CheckingDisabled = r.CheckingDisabled
+			copy(m.Question, r.Question)
+		} else {
+			m = recs.reply(r)
+		}
+		r.Rcode = m.Rcode
+
+		if minTTL > 0 || maxTTL > 0 {
+			m.Answer = clampTTLs(m.Answer, minTTL, maxTTL)
+			m.Ns = clampTTLs(m.Ns, minTTL, maxTTL)
+			m.Extra = clampTTLs(m.Extra, minTTL, maxTTL)
+		}
+		if debugExtra {
+			addDebugTXT(m, recs.fqdn, handlerType(true))
+		}
+
+		writeMsg(w, r, m)
+	}
+}
+
+// reply returns the reply to r from the local records.
+func (recs records) reply(r *dns.Msg) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	// Local records are never DNSSEC signed, so they're never authenticated.
+	m.AuthenticatedData = false
+
+	if aaaaServfail {
+		for _, question := range r.Question {
+			if question.Qtype == dns.TypeAAAA {
+				m.SetRcode(r, dns.RcodeServerFailure)
+				return m
+			}
+		}
+	}
+
+	for _, question := range r.Question {
+		if rcode, ok := recs.denied(question.Name, question.Qtype); ok {
+			m.SetRcode(r, rcode)
+			return m
+		}
+	}
+
+	// answer
+	var exists, matched bool
+	for _, question := range r.Question {
+		rrs, ok := recs.lookup(question.Name, typeConfuse.answerType(question.Name, question.Qtype))
+		if target, isAlias := recs.aliasTarget(question.Name); isAlias {
+			ok = true
+			if len(rrs) == 0 && (question.Qtype == dns.TypeA || question.Qtype == dns.TypeAAAA) {
+				rrs = recs.aliasAddresses(question.Name, target, question.Qtype)
+			}
+		}
+		exists = exists || ok
+		matched = matched || len(rrs) > 0
+		m.Answer = append(m.Answer, recs.available(rrs)...)
+	}
+
+	if len(m.Answer) == 0 && noProxyOnError && recs.parseErrors > 0 {
+		// The skipped records may have answered the question.
+		m.SetRcode(r, dns.RcodeServerFailure)
+		return m
+	}
+
+	switch {
+	case !exists, matched && len(m.Answer) == 0:
+		// Either the name doesn't exist, or every matching record flapped
+		// out of existence for this query.
+		m.SetRcode(r, dns.RcodeNameError)
+		fallthrough
+	case len(m.Answer) == 0:
+		m.Ns = recs.negativeSOA()
+		return m
+	}
+	m.Answer = orderAnswers(m.Answer, answerOrder, recs.weight)
+	if mxRandomizeEqual {
+		m.Answer = shuffleEqualMX(m.Answer)
+	}
+	m.Answer = reorderAnswers(m.Answer, preferIPv6)
+	m.Answer = limitAnswers(m.Answer, maxAnswers)
+
+	// authority and additional
+	recs.place(r, m)
+	m.Extra = append(m.Extra, recs.mxGlue(m.Answer, m.Extra)...)
+
+	m.Ns = recs.inSection(m.Ns, sectionAuthority)
+	m.Extra = recs.inSection(m.Extra, sectionAdditional)
+
+	return m
+}
+
+// seedRNG reseeds the random number generator used for per-query decisions.
+func seedRNG(seed int64) {
+	rngMu.Lock()
+	rng.Seed(seed)
+	rngMu.Unlock()
+}
+
+// randFloat64 returns a pseudo-random number in [0.0,1.0) from the shared,
+// seedable random number generator.
+func randFloat64() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	return rng.Float64()
+}
+
+// proxyTimer identifies what a proxyAfter timer times.
+type proxyTimer int
+
+const (
+	proxyTotalTimer proxyTimer = iota // the total timeout
+	proxyRetryTimer                   // the backoff before a retry
+)
+
+// proxyExchange forwards r upstream with exchangeUpstream. With a total
+// timeout, failed exchanges are retried after an exponential backoff until
+// the timeout expires. It returns the reply and the upstream last tried.
+func proxyExchange(r *dns.Msg) (*dns.Msg, string, error) {
+	if proxySem != nil {
+		proxySem <- struct{}{}
+		defer func() { <-proxySem }()
+	}
+
+	ctx := context.Background()
+	if proxyTotalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+
+		go func(deadline <-chan time.Time) {
+			select {
+			case <-deadline:
+				cancel()
+			case <-ctx.Done():
+			}
+		}(proxyAfter(proxyTotalTimer, proxyTotalTimeout))
+
+		// An abandoned exchange may still be packing the request after
+		// the handler has moved on and mutated it.
+		r = r.Copy()
+	}
+
+	for attempt := 0; ; attempt++ {
+		m, upstream, err := exchangeUpstream(ctx, r)
+		if err == nil || proxyTotalTimeout <= 0 {
+			return m, upstream, err
+		}
+
+		select {
+		case <-ctx.Done():
+			if err == context.Canceled { // by the deadline
+				err = context.DeadlineExceeded
+			}
+			return m, upstream, err
+		case <-proxyAfter(proxyRetryTimer, retryDelay(attempt, proxyRetryInitialDelay, proxyRetryMaxDelay)):
+			proxyRetries.Add(1)
+		}
+	}
+}
+
+// retryDelay returns the backoff before retry attempt+1: initial doubled for
+// each earlier attempt, up to max.
+func retryDelay(attempt int, initial, max time.Duration) time.Duration {
+	d := initial
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	return d
+}
+
+// exchangeUpstream forwards r to the DoH upstream if one is configured, or
+// else to each resolv.conf name server in turn, starting with the one the
+// picker picks, until one answers or ctx is done. It returns the reply and
+// the upstream last tried.
+func exchangeUpstream(ctx context.Context, r *dns.Msg) (*dns.Msg, string, error) {
+	if doh != nil {
+		dc := doh
+		m, err := exchangeContext(ctx, func() (*dns.Msg, error) {
+			return dc.Exchange(r)
+		})
+		return m, dc.url, err
+	}
+
+	var m *dns.Msg
+	var upstream string
+	err := errors.New("no name servers")
+	if clientConfig == nil {
+		return nil, "", err
+	}
+	c, p := client, picker
+	var first string
+	if p != nil {
+		first = p.Next()
+	}
+	observer, _ := p.(latencyObserver)
+	addrs := upstreamAddrs(clientConfig)
+	if hc := upstreamHealth; hc != nil {
+		addrs = hc.alive(addrs)
+	}
+	timeout := proxyTimeout
+	if proxyServerTimeout > 0 {
+		timeout = proxyServerTimeout
+	}
+	for _, addr := range failoverOrder(addrs, first) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		addr := addr
+		upstream = addr
+		start := time.Now()
+		m, err = exchangeContext(ctx, func() (*dns.Msg, error) {
+			m, _, err := c.Exchange(r, addr)
+			return m, err
+		})
+		elapsed := time.Since(start)
+		if err != nil && elapsed < timeout {
+			// A fast failure mustn't make a server look fast.
+			elapsed = timeout
+		}
+		if observer != nil {
+			observer.Observe(addr, elapsed)
+		}
+		if err == nil {
+			break
+		}
+	}
+
+	return m, upstream, err
+}
+
+// upstreamAddrs returns the addresses of the name servers in cfg.
+func upstreamAddrs(cfg *dns.ClientConfig) []string {
+	addrs := make([]string, 0, len(cfg.Servers))
+	for _, ns := range cfg.Servers {
+		addrs = append(addrs, net.JoinHostPort(ns, cfg.Port))
+	}
+
+	return addrs
+}
+
+// exchangeContext returns the result of exchange, or the context's error if
+// it's done first.
+func exchangeContext(ctx context.Context, exchange func() (*dns.Msg, error)) (*dns.Msg, error) {
+	type result struct {
+		m   *dns.Msg
+		err error
+	}
+
+	// Buffered so an abandoned exchange doesn't leak its goroutine.
+	ch := make(chan result, 1)
+	go func() {
+		m, err := exchange()
+		ch <- result{m, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.m, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func proxyHandler(w dns.ResponseWriter, r *dns.Msg) {
+	if localOnly {
+		respond(w, r, dns.RcodeRefused)
+		return
+	}
+
+	var m *dns.Msg
+	err := errors.New("not proxied")
+
+	if proxy {
+		var upstream string
+		m, upstream, err = proxyFlights.Do(flightKey(r), func() (*dns.Msg, string, error) {
+			return proxyExchange(r)
+		})
+		if upstream != "" {
+			setSpanAttribute(w, attrUpstream, upstream)
+		}
+		if err != nil {
+			log.Printf("Proxying %s: %s", flightKey(r), err)
+		}
+		if serveStale {
+			if err == nil {
+				staleAnswers.store(flightKey(r), m, time.Now())
+			} else if sm, ok := staleAnswers.lookup(flightKey(r), time.Now()); ok {
+				m, err = sm, nil
+			}
+		}
+		if m != nil {
+			// The reply may be shared with concurrent identical requests,
+			// which may differ in ID and question case.
+			m = m.Copy()
+			m.Id = r.Id
+			m.CheckingDisabled = r.CheckingDisabled
+			copy(m.Question, r.Question)
+			r.Rcode = m.Rcode
+
+			// Only signal upstream validation to clients that asked for it
+			// (RFC 6840 5.7 and 5.8).
+			if !r.AuthenticatedData && !doBit(r) {
+				m.AuthenticatedData = false
+			}
+			if stripDNSSECRRs {
+				stripDNSSEC(m)
+			}
+		}
+	}
+
+	if err != nil {
+		if m == nil {
+			m = new(dns.Msg)
+		}
+		m.SetRcode(r, dns.RcodeServerFailure)
+		r.Rcode = dns.RcodeServerFailure
+	}
+	if debugExtra {
+		addDebugTXT(m, ".", handlerType(false))
+	}
+
+	writeMsg(w, r, m)
+}
+
+// handlerType names the handler answering a request: local, refused, proxied,
+// or terminal.
+func handlerType(local bool) string {
+	switch {
+	case local:
+		return "local"
+	case localOnly:
+		return "refused"
+	case proxy:
+		return "proxied"
+	default:
+		return "terminal"
+	}
+}
+
+// respond writes an empty reply to r with the given rcode, mirroring the
+// rcode to r for logRequest.
+func respond(w dns.ResponseWriter, r *dns.Msg, rcode int) {
+	m := new(dns.Msg)
+	m.SetRcode(r, rcode)
+	r.Rcode = rcode
+	writeMsg(w, r, m)
+}
+
+// writeMsg applies response post-processing to the reply m to request r
+// before writing it to w.
+func writeMsg(w dns.ResponseWriter, r, m *dns.Msg) {
+	m.Answer = rewrites.apply(m.Answer)
+	if cookieSecret != "" {
+		addCookie(cookieSecret, w.RemoteAddr(), r, m)
+	}
+	echoEdns0(r, m)
+	if nsid != "" {
+		addNSID(nsid, r, m)
+	}
+	setExtendedRcode(m)
+
+	if padTo > 0 {
+		padResponse(r, m, padTo)
+	}
+
+	responseSize.Observe(float64(m.Len()))
+	err := w.WriteMsg(m)
+	if err != nil {
+		log.Printf("Writing response: %s", err)
+	}
+}
+
+func logRequest(local bool, f func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		start := time.Now()
+		id := requestID(w)
+		if minLatency > 0 {
+			w = flooredWriter{ResponseWriter: w, until: start.Add(minLatency)}
+		}
+
+		typ := handlerType(local)
+		if capture != nil {
+			err := capture.Capture(w, typ, r)
+			if err != nil {
+				log.Printf("Capturing query: %s", err)
+			}
+		}
+
+		var sp *span
+		if tracer != nil {
+			sp = startSpan(r, typ)
+			if id != "" {
+				sp.Attributes[attrRequestID] = id
+			}
+			w = &tracedWriter{ResponseWriter: w, span: sp}
+		}
+
+		withQueryTimeout(queryTimeout, func(w dns.ResponseWriter, r *dns.Msg) {
+			if d := responseDelay() + answerDelays.delay(r); d > 0 {
+				time.Sleep(d)
+			}
+
+			cookieRcode := dns.RcodeSuccess
+			if cookieSecret != "" && cookieEnforce {
+				cookieRcode = checkCookie(cookieSecret, w.RemoteAddr(), r)
+			}
+
+			switch {
+			case start.Before(readyAt):
+				respond(w, r, dns.RcodeServerFailure)
+			case refuseMultiQ && len(r.Question) > 1:
+				respond(w, r, dns.RcodeRefused)
+			case ednsVersion(r) > 0:
+				// Only EDNS version 0 is supported (RFC 6891 6.1.3).
+				respond(w, r, dns.RcodeBadVers)
+			case cookieRcode != dns.RcodeSuccess:
+				respond(w, r, cookieRcode)
+			default:
+				f(w, r)
+			}
+		})(w, r)
+		queryDuration.Observe(time.Since(start).Seconds())
+
+		if sp != nil {
+			sp.End = time.Now()
+			sp.Attributes[attrOutcome] = dns.RcodeToString[r.Rcode]
+			tracer.ExportSpan(sp)
+		}
+
+		if verbose {
+			var res string
+			t := handlerColors[typ]
+
+			// We don't have access to the reply Rcode, so we'll rely on the fact that
+			// we mirror the reply Rcode to the request for its reference in middleware.
+			if r.Rcode == 0 {
+				res = cSuccess
+			} else {
+				res = cFailure
+			}
+
+			for _, q := range r.Question {
+				if id != "" {
+					logRequestID(id, "[%s,%s]: %s", t, res, strings.TrimLeft(q.String(), ";"))
+					continue
+				}
+				log.Printf("[%s,%s]: %s", t, res, strings.TrimLeft(q.String(), ";"))
+			}
+		}
+	}
+}
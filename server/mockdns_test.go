@@ -0,0 +1,693 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testResponseWriter is a dns.ResponseWriter that captures the reply.
+type testResponseWriter struct {
+	msg    *dns.Msg
+	remote net.Addr
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	if w.remote != nil {
+		return w.remote
+	}
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+}
+
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+
+func (w *testResponseWriter) Write(b []byte) (int, error) {
+	w.msg = new(dns.Msg)
+	return len(b), w.msg.Unpack(b)
+}
+
+func (w *testResponseWriter) Close() error        { return nil }
+func (w *testResponseWriter) TsigStatus() error   { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool) {}
+func (w *testResponseWriter) Hijack()             {}
+
+// loadTestData unmarshals the given JSON into data, failing the test on error.
+func loadTestData(t *testing.T, j string) data {
+	t.Helper()
+
+	d := make(data)
+	err := json.Unmarshal([]byte(j), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// query sends a single question for name and qtype to h and returns the reply.
+func query(h func(dns.ResponseWriter, *dns.Msg), name string, qtype uint16) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetQuestion(name, qtype)
+
+	w := new(testResponseWriter)
+	h(w, r)
+
+	return w.msg
+}
+
+func TestHandlerAvailability(t *testing.T) {
+	d := loadTestData(t, `{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1", "availability": "0.5"}
+	]}}`)
+	h := handler(d["test.com."])
+
+	const n = 1000
+	run := func() []int {
+		seedRNG(42)
+		rcodes := make([]int, n)
+		for i := range rcodes {
+			rcodes[i] = query(h, "test.com.", dns.TypeA).Rcode
+		}
+		return rcodes
+	}
+
+	first := run()
+	var successes int
+	for _, rcode := range first {
+		switch rcode {
+		case dns.RcodeSuccess:
+			successes++
+		case dns.RcodeNameError:
+		default:
+			t.Fatalf("unexpected rcode %s", dns.RcodeToString[rcode])
+		}
+	}
+	if successes < n*4/10 || successes > n*6/10 {
+		t.Fatalf("expected roughly %d successes; actual: %d", n/2, successes)
+	}
+
+	second := run()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("query %d: expected deterministic rcode %d; actual: %d", i, first[i], second[i])
+		}
+	}
+}
+
+func TestInvalidAvailability(t *testing.T) {
+	t.Parallel()
+
+	d := make(data)
+	err := json.Unmarshal([]byte(`{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1", "availability": "1.5"}
+	]}}`), &d)
+	if err == nil {
+		t.Fatal("expected invalid availability error")
+	}
+}
+
+func TestProxyHandlerLocalOnly(t *testing.T) {
+	defer func(v bool) { localOnly = v }(localOnly)
+	localOnly = true
+
+	m := query(proxyHandler, "unmatched.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestMultiQuestion(t *testing.T) {
+	defer func(v bool) { refuseMultiQ = v }(refuseMultiQ)
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "@", "value": "10.0.0.1"}],
+		"aaaa": [{"hostname": "@", "value": "fd00::1"}]
+	}}`)
+	h := logRequest(true, handler(d["test.com."]))
+
+	for _, c := range []struct {
+		refuse  bool
+		rcode   int
+		answers int
+	}{
+		{false, dns.RcodeSuccess, 2},
+		{true, dns.RcodeRefused, 0},
+	} {
+		refuseMultiQ = c.refuse
+
+		r := new(dns.Msg)
+		r.SetQuestion("test.com.", dns.TypeA)
+		r.Question = append(r.Question, dns.Question{
+			Name: "test.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET,
+		})
+
+		w := new(testResponseWriter)
+		h(w, r)
+
+		if w.msg.Rcode != c.rcode {
+			t.Errorf("refuse=%t: expected %s; actual: %s", c.refuse,
+				dns.RcodeToString[c.rcode], dns.RcodeToString[w.msg.Rcode])
+		}
+		if len(w.msg.Answer) != c.answers {
+			t.Errorf("refuse=%t: expected %d answers; actual: %d", c.refuse,
+				c.answers, len(w.msg.Answer))
+		}
+	}
+}
+
+func TestHandlerMissingAAAA(t *testing.T) {
+	defer func(v bool) { aaaaServfail = v }(aaaaServfail)
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	h := handler(d["test.com."])
+
+	aaaaServfail = false
+	m := query(h, "test.com.", dns.TypeAAAA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if len(m.Answer) != 0 {
+		t.Fatalf("expected empty answer; actual: %v", m.Answer)
+	}
+
+	aaaaServfail = true
+	m = query(h, "test.com.", dns.TypeAAAA)
+	if m.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+
+	m = query(h, "test.com.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected A answer; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+}
+
+func TestHandlerNegativeSOA(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"soa": [{"hostname": "@", "ttl": "3600", "value": "ns1.test.com. hostmaster.test.com. 1 7200 3600 1209600 300"}],
+		"a": [{"hostname": "www", "value": "10.0.0.1"}]
+	}}`)
+	h := handler(d["test.com."])
+
+	for _, c := range []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		{"missing.test.com.", dns.TypeA, dns.RcodeNameError},
+		{"www.test.com.", dns.TypeAAAA, dns.RcodeSuccess},
+	} {
+		m := query(h, c.name, c.qtype)
+		if m.Rcode != c.rcode {
+			t.Errorf("%s: expected %s; actual: %s", c.name, dns.RcodeToString[c.rcode], dns.RcodeToString[m.Rcode])
+		}
+		if len(m.Answer) != 0 {
+			t.Errorf("%s: expected empty answer; actual: %v", c.name, m.Answer)
+		}
+		if len(m.Ns) != 1 {
+			t.Fatalf("%s: expected SOA in authority; actual: %v", c.name, m.Ns)
+		}
+		soa, ok := m.Ns[0].(*dns.SOA)
+		if !ok {
+			t.Fatalf("%s: expected *dns.SOA; actual: %T", c.name, m.Ns[0])
+		}
+		if soa.Hdr.Ttl != 300 {
+			t.Errorf("%s: expected negative TTL 300; actual: %d", c.name, soa.Hdr.Ttl)
+		}
+	}
+
+	// The stored SOA keeps its own TTL.
+	if ttl := d["test.com."].data[dns.TypeSOA][0].Header().Ttl; ttl != 3600 {
+		t.Fatalf("expected stored SOA TTL 3600; actual: %d", ttl)
+	}
+}
+
+func TestHandlerOwnerMatching(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [
+			{"hostname": "www1", "value": "10.0.0.1"},
+			{"hostname": "www2", "value": "10.0.0.2"}
+		],
+		"cname": [{"hostname": "mail", "value": "www1.test.com."}]
+	}}`)
+	h := handler(d["test.com."])
+
+	m := query(h, "WWW1.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Fatalf("expected only www1's A record; actual: %v", m.Answer)
+	}
+
+	m = query(h, "mail.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].Header().Rrtype != dns.TypeCNAME {
+		t.Fatalf("expected mail's CNAME; actual: %v", m.Answer)
+	}
+
+	// The apex is an empty non-terminal: NOERROR with no answers.
+	m = query(h, "test.com.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 0 {
+		t.Fatalf("expected NODATA for apex; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+}
+
+func TestStartupDelay(t *testing.T) {
+	defer func(v time.Time) { readyAt = v }(readyAt)
+	readyAt = time.Now().Add(100 * time.Millisecond)
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	h := logRequest(true, handler(d["test.com."]))
+
+	m := query(h, "test.com.", dns.TypeA)
+	if m.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL during startup delay; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+
+	time.Sleep(time.Until(readyAt))
+
+	m = query(h, "test.com.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected answer after startup delay; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+}
+
+func TestHandlerClearsAD(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	h := handler(d["test.com."])
+
+	for _, cd := range []bool{false, true} {
+		r := new(dns.Msg)
+		r.SetQuestion("test.com.", dns.TypeA)
+		r.AuthenticatedData = true
+		r.CheckingDisabled = cd
+
+		w := new(testResponseWriter)
+		h(w, r)
+
+		if w.msg.AuthenticatedData {
+			t.Errorf("cd=%t: expected AD bit cleared on unsigned local response", cd)
+		}
+		if w.msg.CheckingDisabled != cd {
+			t.Errorf("expected CD bit %t; actual: %t", cd, w.msg.CheckingDisabled)
+		}
+	}
+}
+
+func TestProxyHandlerAD(t *testing.T) {
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.AuthenticatedData = true
+		w.WriteMsg(m)
+	}))
+
+	for _, ad := range []bool{false, true} {
+		r := new(dns.Msg)
+		r.SetQuestion("proxied-ad.test.", dns.TypeA)
+		r.AuthenticatedData = ad
+
+		w := new(testResponseWriter)
+		proxyHandler(w, r)
+
+		if w.msg.AuthenticatedData != ad {
+			t.Errorf("request AD=%t: expected response AD %t; actual: %t", ad, ad, w.msg.AuthenticatedData)
+		}
+	}
+}
+
+func TestProxyConcurrency(t *testing.T) {
+	const limit = 3
+
+	var inFlight, peak int32
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -1)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	}))
+
+	defer func(v chan struct{}) { proxySem = v }(proxySem)
+	proxySem = make(chan struct{}, limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5*limit; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			m := query(proxyHandler, fmt.Sprintf("name%d.concurrency.test.", i), dns.TypeA)
+			if m.Rcode != dns.RcodeSuccess {
+				t.Errorf("query %d: expected NOERROR; actual: %s", i, dns.RcodeToString[m.Rcode])
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if peak > limit {
+		t.Fatalf("expected at most %d concurrent upstream exchanges; actual: %d", limit, peak)
+	}
+	if peak < 2 {
+		t.Fatalf("expected concurrent upstream exchanges; actual peak: %d", peak)
+	}
+}
+
+func TestLoadDataFileStdin(t *testing.T) {
+	f, err := ioutil.TempFile("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	_, err = f.WriteString(`{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(v *os.File) { os.Stdin = v }(os.Stdin)
+	os.Stdin = f
+
+	d, err := loadDataFile("-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d["test.com."].data[dns.TypeA]) != 1 {
+		t.Fatalf("expected A record from stdin; actual: %v", d)
+	}
+}
+
+// observingPicker is a ResolverPicker recording the latencies it's given.
+type observingPicker struct {
+	mu       sync.Mutex
+	observed map[string]time.Duration
+}
+
+func (p *observingPicker) Next() string { return "" }
+
+func (p *observingPicker) Observe(server string, d time.Duration) {
+	p.mu.Lock()
+	p.observed[server] = d
+	p.mu.Unlock()
+}
+
+func TestExchangeUpstreamFastFailure(t *testing.T) {
+	stub := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Id = r.Id + 1 // fails the exchange right away
+		w.WriteMsg(m)
+	})
+	useUpstreams(t, stub)
+	p := &observingPicker{observed: make(map[string]time.Duration)}
+	picker = p
+
+	defer func(v, s time.Duration) { proxyTimeout, proxyServerTimeout = v, s }(proxyTimeout, proxyServerTimeout)
+	proxyTimeout, proxyServerTimeout = time.Hour, 50*time.Millisecond
+
+	r := new(dns.Msg)
+	r.SetQuestion("fast.test.", dns.TypeA)
+	_, _, err := exchangeUpstream(context.Background(), r)
+	if err == nil {
+		t.Fatal("expected the exchange to fail")
+	}
+	if d := p.observed[stub]; d != proxyServerTimeout {
+		t.Fatalf("expected the fast failure observed as the server timeout %s; actual: %s", proxyServerTimeout, d)
+	}
+}
+
+func TestProxyTotalTimeout(t *testing.T) {
+	deadline := make(chan time.Time)
+	var attempts int32
+	stub := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		// Never answer, and hit the total timeout during the second attempt.
+		if atomic.AddInt32(&attempts, 1) == 2 {
+			close(deadline)
+		}
+	})
+	useUpstreams(t, stub, stub, stub)
+	client = &dns.Client{
+		DialTimeout:  50 * time.Millisecond,
+		ReadTimeout:  50 * time.Millisecond,
+		WriteTimeout: 50 * time.Millisecond,
+	}
+
+	defer func(v time.Duration) { proxyTotalTimeout = v }(proxyTotalTimeout)
+	proxyTotalTimeout = time.Hour
+
+	defer func(f func(proxyTimer, time.Duration) <-chan time.Time) { proxyAfter = f }(proxyAfter)
+	proxyAfter = func(timer proxyTimer, d time.Duration) <-chan time.Time {
+		if timer == proxyTotalTimer {
+			return deadline
+		}
+		return time.After(d)
+	}
+
+	m := query(proxyHandler, "timeout.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Fatalf("expected 2 upstream attempts within the total timeout; actual: %d", n)
+	}
+}
+
+func TestHandlerTypeDenial(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}],
+		"txt": [{"hostname": "www", "deny": "REFUSED"}]
+	}}`)
+	h := handler(d["test.com."])
+
+	m := query(h, "www.test.com.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected A answer; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+
+	m = query(h, "WWW.test.com.", dns.TypeTXT)
+	if m.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if len(m.Answer) != 0 {
+		t.Fatalf("expected no answers; actual: %v", m.Answer)
+	}
+
+	err := json.Unmarshal([]byte(`{"test.com.": {"txt": [{"hostname": "www", "deny": "BOGUS"}]}}`), &d)
+	if err == nil {
+		t.Fatal("expected invalid deny rcode error")
+	}
+}
+
+func TestHandlerDenyAfter(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [
+			{"hostname": "www", "value": "10.0.0.1"},
+			{"hostname": "www", "deny": "SERVFAIL", "deny_after": "2"}
+		],
+		"txt": [
+			{"hostname": "www", "value": "ok"},
+			{"hostname": "www", "deny": "SERVFAIL", "deny_until": "2"}
+		]
+	}}`)
+	h := handler(d["test.com."])
+
+	for i, expected := range []int{
+		dns.RcodeSuccess, dns.RcodeSuccess, dns.RcodeServerFailure, dns.RcodeServerFailure,
+	} {
+		m := query(h, "www.test.com.", dns.TypeA)
+		if m.Rcode != expected {
+			t.Errorf("A query %d: expected %s; actual: %s", i+1, dns.RcodeToString[expected], dns.RcodeToString[m.Rcode])
+		}
+		if expected == dns.RcodeSuccess && len(m.Answer) != 1 {
+			t.Errorf("A query %d: expected 1 answer; actual: %v", i+1, m.Answer)
+		}
+	}
+
+	for i, expected := range []int{
+		dns.RcodeServerFailure, dns.RcodeServerFailure, dns.RcodeSuccess, dns.RcodeSuccess,
+	} {
+		m := query(h, "www.test.com.", dns.TypeTXT)
+		if m.Rcode != expected {
+			t.Errorf("TXT query %d: expected %s; actual: %s", i+1, dns.RcodeToString[expected], dns.RcodeToString[m.Rcode])
+		}
+	}
+
+	for _, j := range []string{
+		`{"test.com.": {"a": [{"deny": "SERVFAIL", "deny_after": "0"}]}}`,
+		`{"test.com.": {"a": [{"deny": "SERVFAIL", "deny_after": "x"}]}}`,
+		`{"test.com.": {"a": [{"deny": "SERVFAIL", "deny_after": "1", "deny_until": "1"}]}}`,
+	} {
+		if err := json.Unmarshal([]byte(j), &d); err == nil {
+			t.Errorf("expected error for %s", j)
+		}
+	}
+}
+
+func TestPreserveCase(t *testing.T) {
+	defer func(v bool) { preserveCase = v }(preserveCase)
+	preserveCase = true
+
+	d := loadTestData(t, `{"Test.COM": {"a": [{"hostname": "WWW", "value": "10.0.0.1"}]}}`)
+	recs, ok := d["Test.COM."]
+	if !ok {
+		t.Fatalf("expected domain Test.COM.; actual: %v", d)
+	}
+	if name := recs.data[dns.TypeA][0].Header().Name; name != "WWW.Test.COM." {
+		t.Errorf("expected owner WWW.Test.COM.; actual: %q", name)
+	}
+
+	s := NewServer(d)
+	for _, name := range []string{"www.test.com.", "WWW.TEST.COM.", "wWw.TeSt.CoM."} {
+		m := query(s.ServeDNS, name, dns.TypeA)
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+			t.Errorf("%s: expected 1 answer; actual: %v", name, m)
+		}
+	}
+}
+
+func TestHandlerCoalescing(t *testing.T) {
+	defer func(v bool) { coalesceLocal = v }(coalesceLocal)
+	coalesceLocal = true
+
+	h := handler(loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`)["test.com."])
+
+	names := []string{"www.test.com.", "WWW.test.com.", "www.TEST.com."}
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 60; i++ {
+		wg.Add(1)
+		go func(id uint16, name string) {
+			defer wg.Done()
+
+			r := new(dns.Msg)
+			r.SetQuestion(name, dns.TypeA)
+			r.Id = id
+			w := new(testResponseWriter)
+			<-start
+			h(w, r)
+
+			switch m := w.msg; {
+			case m.Id != id:
+				t.Errorf("expected ID %d; actual: %d", id, m.Id)
+			case m.Question[0].Name != name:
+				t.Errorf("expected question %q; actual: %q", name, m.Question[0].Name)
+			case len(m.Answer) != 1:
+				t.Errorf("expected 1 answer; actual: %v", m.Answer)
+			}
+		}(uint16(i), names[i%len(names)])
+	}
+	close(start)
+	wg.Wait()
+}
+
+func BenchmarkHandlerCoalescing(b *testing.B) {
+	defer func(v bool) { coalesceLocal = v }(coalesceLocal)
+
+	// Many records make each lookup costly while keeping the reply small.
+	var sb strings.Builder
+	sb.WriteString(`{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}], "txt": [`)
+	for i := 0; i < 1000; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"hostname": "h%d", "value": "v%d"}`, i, i)
+	}
+	sb.WriteString(`]}}`)
+	d := make(data)
+	if err := json.Unmarshal([]byte(sb.String()), &d); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, coalesce := range []bool{false, true} {
+		b.Run(fmt.Sprintf("coalesce=%t", coalesce), func(b *testing.B) {
+			coalesceLocal = coalesce
+			h := handler(d["test.com."])
+
+			b.SetParallelism(100)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					query(h, "www.test.com.", dns.TypeA)
+				}
+			})
+		})
+	}
+}
+
+func TestProxyRetryBackoff(t *testing.T) {
+	var attempts int32
+	stub := newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			return // time out the first two attempts
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	useUpstreams(t, stub)
+	client = &dns.Client{Timeout: 50 * time.Millisecond}
+
+	defer func(total, initial, max time.Duration) {
+		proxyTotalTimeout, proxyRetryInitialDelay, proxyRetryMaxDelay = total, initial, max
+	}(proxyTotalTimeout, proxyRetryInitialDelay, proxyRetryMaxDelay)
+	proxyTotalTimeout, proxyRetryInitialDelay, proxyRetryMaxDelay = 2*time.Second, 10*time.Millisecond, time.Second
+
+	before := proxyRetries.Value()
+	m := query(proxyHandler, "retry.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR after retries; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if n := proxyRetries.Value() - before; n != 2 {
+		t.Fatalf("expected 2 retries; actual: %d", n)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+
+	for attempt, expected := range []time.Duration{
+		50 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+		400 * time.Millisecond, 800 * time.Millisecond, time.Second, time.Second,
+	} {
+		if actual := retryDelay(attempt, 50*time.Millisecond, time.Second); actual != expected {
+			t.Errorf("attempt %d: expected %s; actual: %s", attempt, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// OpenPGPKeyName returns the OPENPGPKEY owner name for the email address
+// localpart@domain (RFC 7929 3): the hex-encoded SHA2-256 hash of the
+// lowercased local part, truncated to 28 octets, under _openpgpkey.domain.
+func OpenPGPKeyName(localpart, domain string) string {
+	return hashedOwnerName(localpart, "_openpgpkey", domain)
+}
+
+// hashedOwnerName returns the owner name label for localpart, hashed as
+// OPENPGPKEY and SMIMEA records require, under the service label in domain.
+func hashedOwnerName(localpart, service, domain string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(localpart)))
+
+	return dns.Fqdn(hex.EncodeToString(sum[:28]) + "." + service + "." + strings.TrimSuffix(domain, "."))
+}
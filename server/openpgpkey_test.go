@@ -0,0 +1,19 @@
+package server
+
+import "testing"
+
+func TestOpenPGPKeyName(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7929 3 example for hugh@example.com.
+	expected := "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._openpgpkey.example.com."
+	for _, tc := range []struct{ localpart, domain string }{
+		{"hugh", "example.com"},
+		{"hugh", "example.com."},
+		{"Hugh", "example.com"},
+	} {
+		if actual := OpenPGPKeyName(tc.localpart, tc.domain); actual != expected {
+			t.Errorf("%s@%s: expected %q; actual: %q", tc.localpart, tc.domain, expected, actual)
+		}
+	}
+}
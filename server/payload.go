@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// dnsHeaderSize is the size of a DNS message header, the smallest message.
+const dnsHeaderSize = 12
+
+// errOversized reports a message larger than -max-payload. It's temporary so
+// the UDP server drops the datagram and keeps reading, while the TCP server
+// closes the connection.
+type errOversized int
+
+func (e errOversized) Error() string {
+	return fmt.Sprintf("%d-byte message exceeds -max-payload %d", int(e), maxPayload)
+}
+
+func (e errOversized) Timeout() bool   { return false }
+func (e errOversized) Temporary() bool { return true }
+
+var _ net.Error = errOversized(0)
+
+// payloadReader is a dns.Reader rejecting messages larger than max bytes
+// before they're unpacked.
+type payloadReader struct {
+	dns.Reader
+	max int
+}
+
+func (r payloadReader) ReadTCP(conn net.Conn, timeout time.Duration) ([]byte, error) {
+	b, err := r.Reader.ReadTCP(conn, timeout)
+	if err == nil && len(b) > r.max {
+		err = errOversized(len(b))
+		log.Printf("Warning: closing connection from %s: %s", conn.RemoteAddr(), err)
+	}
+
+	return b, err
+}
+
+func (r payloadReader) ReadUDP(conn *net.UDPConn, timeout time.Duration) ([]byte, *dns.SessionUDP, error) {
+	b, s, err := r.Reader.ReadUDP(conn, timeout)
+	if err == nil && len(b) > r.max {
+		err = errOversized(len(b))
+		log.Printf("Warning: dropping datagram from %s: %s", s.RemoteAddr(), err)
+	}
+
+	return b, s, err
+}
+
+// udpBufferSize returns the UDP read buffer size needed to tell datagrams
+// larger than max bytes from those that fit.
+func udpBufferSize(max int) int {
+	if max >= dns.MaxMsgSize {
+		return dns.MaxMsgSize
+	}
+
+	return max + 1
+}
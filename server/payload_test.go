@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestMaxPayload(t *testing.T) {
+	var buf lockedBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	defer func(v int) { maxPayload = v }(maxPayload)
+	maxPayload = 512
+
+	s := NewServer(loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`))
+	pc, l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	for _, server := range []*dns.Server{{Net: "udp", PacketConn: pc}, {Net: "tcp", Listener: l}} {
+		wg.Add(1)
+		go func(server *dns.Server) {
+			serve(ctx, server, s)
+			wg.Done()
+		}(server)
+	}
+
+	small := new(dns.Msg)
+	small.SetQuestion("test.com.", dns.TypeA)
+
+	// Padding the additional section takes the query past 512 bytes.
+	large := small.Copy()
+	large.Extra = []dns.RR{&dns.TXT{
+		Hdr: dns.RR_Header{Name: "test.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+		Txt: []string{strings.Repeat("x", 255), strings.Repeat("x", 255)},
+	}}
+
+	for _, network := range []string{"udp", "tcp"} {
+		c := &dns.Client{Net: network, Timeout: 250 * time.Millisecond, UDPSize: dns.MaxMsgSize}
+
+		if _, _, err := c.Exchange(large, s.Addr()); err == nil {
+			t.Errorf("%s: expected oversized query to be rejected", network)
+		}
+
+		m, _, err := c.Exchange(small, s.Addr())
+		if err != nil {
+			t.Fatalf("%s: expected the server to keep answering: %s", network, err)
+		}
+		if len(m.Answer) != 1 {
+			t.Errorf("%s: expected 1 answer; actual: %v", network, m.Answer)
+		}
+	}
+
+	if out := buf.String(); strings.Count(out, "exceeds -max-payload 512") != 2 {
+		t.Errorf("expected a warning per rejected query; actual log: %s", out)
+	}
+	if out := buf.String(); strings.Contains(out, "Malformed") {
+		t.Errorf("expected oversized queries not to be logged as malformed; actual log: %s", out)
+	}
+}
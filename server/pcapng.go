@@ -0,0 +1,165 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// pcapng block types, options and the link type of its packets
+// (draft-ietf-opsawg-pcapng).
+const (
+	pcapngSectionHeader    = 0x0a0d0d0a
+	pcapngInterface        = 0x00000001
+	pcapngEnhancedPacket   = 0x00000006
+	pcapngByteOrderMagic   = 0x1a2b3c4d
+	pcapngOptEndOfOpt      = 0
+	pcapngOptComment       = 1
+	pcapngLinkTypeRaw      = 101 // bare IPv4 or IPv6 packets
+	pcapngMaxUDPPayloadLen = 0xffff - 20 - 8
+)
+
+// pcapngHeader returns the section header and the single interface
+// description block every captured packet refers to.
+func pcapngHeader() []byte {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb, pcapngByteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:], 1) // version 1.0
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0))
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb, pcapngLinkTypeRaw)
+
+	return append(pcapngBlock(pcapngSectionHeader, shb), pcapngBlock(pcapngInterface, idb)...)
+}
+
+// pcapngPacket returns an enhanced packet block holding packet, captured at t
+// (in the default microsecond resolution), with comment attached.
+func pcapngPacket(t time.Time, packet []byte, comment string) []byte {
+	us := uint64(t.UnixNano() / int64(time.Microsecond))
+
+	body := make([]byte, 20, 20+len(packet)+12+len(comment))
+	binary.LittleEndian.PutUint32(body[4:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(body[8:], uint32(us))
+	binary.LittleEndian.PutUint32(body[12:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:], uint32(len(packet)))
+	body = append(body, pad32(packet)...)
+	body = append(body, pcapngOption(pcapngOptComment, []byte(comment))...)
+	body = append(body, pcapngOption(pcapngOptEndOfOpt, nil)...)
+
+	return pcapngBlock(pcapngEnhancedPacket, body)
+}
+
+// pcapngBlock returns the block of type typ with body, which must be padded
+// to 32 bits.
+func pcapngBlock(typ uint32, body []byte) []byte {
+	n := 12 + len(body)
+	b := make([]byte, n)
+	binary.LittleEndian.PutUint32(b, typ)
+	binary.LittleEndian.PutUint32(b[4:], uint32(n))
+	copy(b[8:], body)
+	binary.LittleEndian.PutUint32(b[n-4:], uint32(n))
+
+	return b
+}
+
+// pcapngOption returns the option with code and value, padded to 32 bits.
+func pcapngOption(code uint16, value []byte) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b, code)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(value)))
+
+	return append(b, pad32(value)...)
+}
+
+// pad32 returns b zero-padded to a multiple of 32 bits.
+func pad32(b []byte) []byte {
+	if n := len(b) % 4; n != 0 {
+		b = append(b[:len(b):len(b)], make([]byte, 4-n)...)
+	}
+
+	return b
+}
+
+// udpPacket returns an IPv4 packet, or an IPv6 one if either address is
+// IPv6, carrying payload in a UDP datagram from src to dst. Queries received
+// over TCP are recorded this way too, so capture tools dissect them alike.
+func udpPacket(src, dst net.Addr, payload []byte) ([]byte, error) {
+	if len(payload) > pcapngMaxUDPPayloadLen {
+		return nil, fmt.Errorf("%d-byte message too large to capture", len(payload))
+	}
+
+	srcIP, srcPort := addrIPPort(src)
+	dstIP, dstPort := addrIPPort(dst)
+
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp, uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		// The UDP checksum is optional over IPv4.
+		ip := make([]byte, 20, 20+len(udp))
+		ip[0] = 0x45 // version 4, 5-word header
+		binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+		ip[6] = 0x40 // don't fragment
+		ip[8] = 64   // TTL
+		ip[9] = 17   // UDP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+		return append(ip, udp...), nil
+	}
+
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+	ip[6] = 17 // UDP
+	ip[7] = 64 // hop limit
+	copy(ip[8:], srcIP.To16())
+	copy(ip[24:], dstIP.To16())
+
+	// The UDP checksum is mandatory over IPv6, covering a pseudo-header of
+	// the addresses, length and next header (RFC 8200 8.1).
+	sum := checksum(ip[8:40], uint32(len(udp))+17)
+	sum = checksum(udp, uint32(^sum))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	return append(ip, udp...), nil
+}
+
+// addrIPPort returns the IP address and port of a, or the IPv4 unspecified
+// address if a isn't an IP address.
+func addrIPPort(a net.Addr) (net.IP, int) {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+
+	return net.IPv4zero, 0
+}
+
+// checksum returns the Internet checksum (RFC 1071) of b, starting from the
+// partial sum initial.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+
+	return ^uint16(sum)
+}
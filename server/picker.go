@@ -0,0 +1,130 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream retry strategies.
+const (
+	strategyFirst        = "first"
+	strategyRoundRobin   = "round-robin"
+	strategyRandom       = "random"
+	strategyLeastLatency = "least-latency"
+)
+
+// latencyAlpha is the weight given to each new sample in a server's latency
+// moving average.
+const latencyAlpha = 0.3
+
+// ResolverPicker picks the upstream server a proxied query tries first. The
+// query fails over to the remaining servers in resolv.conf order after it.
+type ResolverPicker interface {
+	Next() string
+}
+
+// latencyObserver is implemented by a ResolverPicker that wants the latency
+// of every exchange with each server.
+type latencyObserver interface {
+	Observe(server string, d time.Duration)
+}
+
+// newResolverPicker returns the ResolverPicker for strategy over servers,
+// which must not be empty.
+func newResolverPicker(strategy string, servers []string) (ResolverPicker, error) {
+	switch strategy {
+	case strategyFirst:
+		return firstPicker(servers), nil
+	case strategyRoundRobin:
+		return &roundRobinPicker{servers: servers}, nil
+	case strategyRandom:
+		return randomPicker(servers), nil
+	case strategyLeastLatency:
+		return &leastLatencyPicker{servers: servers, ewma: make(map[string]time.Duration)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown upstream retry strategy %q", strategy)
+}
+
+// failoverOrder returns servers in the order a query starting at first tries
+// them: first, then those after it, wrapping around.
+func failoverOrder(servers []string, first string) []string {
+	for i, s := range servers {
+		if s == first {
+			out := make([]string, 0, len(servers))
+			return append(append(out, servers[i:]...), servers[:i]...)
+		}
+	}
+
+	return servers
+}
+
+// firstPicker always picks the first server.
+type firstPicker []string
+
+func (p firstPicker) Next() string { return p[0] }
+
+// roundRobinPicker picks each server in turn. It's safe for concurrent use.
+type roundRobinPicker struct {
+	servers []string
+	n       uint32
+}
+
+func (p *roundRobinPicker) Next() string {
+	i := atomic.AddUint32(&p.n, 1) - 1
+
+	return p.servers[int(i%uint32(len(p.servers)))]
+}
+
+// randomPicker picks a server at random.
+type randomPicker []string
+
+func (p randomPicker) Next() string {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	return p[rng.Intn(len(p))]
+}
+
+// leastLatencyPicker picks the server with the lowest exponentially weighted
+// moving average latency. Servers without a latency sample are picked first,
+// in order, so each is measured. It's safe for concurrent use.
+type leastLatencyPicker struct {
+	servers []string
+
+	mu   sync.Mutex
+	ewma map[string]time.Duration
+}
+
+func (p *leastLatencyPicker) Next() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best string
+	for _, s := range p.servers {
+		avg, ok := p.ewma[s]
+		if !ok {
+			return s
+		}
+		if best == "" || avg < p.ewma[best] {
+			best = s
+		}
+	}
+
+	return best
+}
+
+// Observe folds the latency of an exchange with server into its average.
+func (p *leastLatencyPicker) Observe(server string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	avg, ok := p.ewma[server]
+	if !ok {
+		p.ewma[server] = d
+		return
+	}
+	p.ewma[server] = avg + time.Duration(latencyAlpha*float64(d-avg))
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestLeastLatencyConverges(t *testing.T) {
+	answer := func(hits *int32, delay time.Duration) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			atomic.AddInt32(hits, 1)
+			time.Sleep(delay)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			w.WriteMsg(m)
+		}
+	}
+
+	var slowHits, fastHits int32
+	slow := newStubUpstream(t, answer(&slowHits, 50*time.Millisecond))
+	_, port, _ := net.SplitHostPort(slow)
+	fast := newStubUpstreamAt(t, net.JoinHostPort("127.0.0.2", port), answer(&fastHits, 0))
+	useUpstreams(t, slow, fast)
+
+	var err error
+	picker, err = newResolverPicker(strategyLeastLatency, upstreamAddrs(clientConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first two queries measure each server in turn.
+	for i := 0; i < 12; i++ {
+		m := query(proxyHandler, fmt.Sprintf("q%d.test.", i), dns.TypeA)
+		if m.Rcode != dns.RcodeSuccess {
+			t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[m.Rcode])
+		}
+	}
+
+	if s, f := atomic.LoadInt32(&slowHits), atomic.LoadInt32(&fastHits); s != 1 || f != 11 {
+		t.Fatalf("expected 1 slow and 11 fast queries; actual: %d and %d", s, f)
+	}
+}
+
+func TestResolverPickers(t *testing.T) {
+	t.Parallel()
+
+	servers := []string{"a", "b", "c"}
+	next := func(p ResolverPicker, n int) string {
+		picks := make([]string, n)
+		for i := range picks {
+			picks[i] = p.Next()
+		}
+		return fmt.Sprint(picks)
+	}
+
+	p, _ := newResolverPicker(strategyFirst, servers)
+	if actual := next(p, 4); actual != "[a a a a]" {
+		t.Errorf("first: expected [a a a a]; actual: %s", actual)
+	}
+
+	p, _ = newResolverPicker(strategyRoundRobin, servers)
+	if actual := next(p, 6); actual != "[a b c a b c]" {
+		t.Errorf("round-robin: expected [a b c a b c]; actual: %s", actual)
+	}
+
+	p, _ = newResolverPicker(strategyRandom, servers)
+	for i := 0; i < 10; i++ {
+		if s := p.Next(); s != "a" && s != "b" && s != "c" {
+			t.Fatalf("random: unexpected server %q", s)
+		}
+	}
+
+	p, _ = newResolverPicker(strategyLeastLatency, servers)
+	o := p.(latencyObserver)
+	o.Observe("a", 30*time.Millisecond)
+	o.Observe("c", 10*time.Millisecond)
+	if s := p.Next(); s != "b" {
+		t.Errorf("least-latency: expected unmeasured b; actual: %s", s)
+	}
+	o.Observe("b", 20*time.Millisecond)
+	if s := p.Next(); s != "c" {
+		t.Errorf("least-latency: expected c; actual: %s", s)
+	}
+	// Sustained fast exchanges overtake a slow start.
+	for i := 0; i < 10; i++ {
+		o.Observe("a", time.Millisecond)
+	}
+	if s := p.Next(); s != "a" {
+		t.Errorf("least-latency: expected a; actual: %s", s)
+	}
+
+	if _, err := newResolverPicker("bogus", servers); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestFailoverOrder(t *testing.T) {
+	t.Parallel()
+
+	servers := []string{"a", "b", "c"}
+	for first, expected := range map[string]string{"a": "[a b c]", "b": "[b c a]", "c": "[c a b]", "": "[a b c]"} {
+		if actual := fmt.Sprint(failoverOrder(servers, first)); actual != expected {
+			t.Errorf("%q: expected %s; actual: %s", first, expected, actual)
+		}
+	}
+}
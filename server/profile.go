@@ -0,0 +1,41 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/pprof"
+)
+
+// profileHandler returns the net/http/pprof handlers under /debug/pprof/.
+func profileHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	return mux
+}
+
+// serveProfile serves the pprof endpoints on addr until ctx is done. They're
+// unauthenticated and expose process internals, so addr should be loopback.
+func serveProfile(ctx context.Context, addr string) {
+	server := &http.Server{Addr: addr, Handler: profileHandler()}
+
+	go func() {
+		<-ctx.Done()
+		err := server.Shutdown(context.Background())
+		if err != nil {
+			log.Println(err)
+		}
+	}()
+
+	log.Printf("Profiling listening on %s ...\n", addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		log.Println(err)
+	}
+	log.Printf("%s profiling listener stopped\n", addr)
+}
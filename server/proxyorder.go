@@ -0,0 +1,30 @@
+package server
+
+import "fmt"
+
+// Upstream server orderings accepted by -proxy-order, which predates
+// -upstream-retry-strategy and is kept as an alias for it.
+const (
+	proxyOrderSequential = "sequential"
+	proxyOrderRandom     = "random"
+	proxyOrderFastest    = "fastest"
+)
+
+// proxyOrderStrategies maps each upstream server ordering to the upstream
+// retry strategy replacing it.
+var proxyOrderStrategies = map[string]string{
+	proxyOrderSequential: strategyFirst,
+	proxyOrderRandom:     strategyRandom,
+	proxyOrderFastest:    strategyLeastLatency,
+}
+
+// proxyOrderStrategy returns the upstream retry strategy for the upstream
+// server ordering order.
+func proxyOrderStrategy(order string) (string, error) {
+	strategy, ok := proxyOrderStrategies[order]
+	if !ok {
+		return "", fmt.Errorf("unknown proxy order %q", order)
+	}
+
+	return strategy, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestProxyOrderFastest(t *testing.T) {
+	answer := func(hits *int32, delay time.Duration) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			atomic.AddInt32(hits, 1)
+			time.Sleep(delay)
+			m := new(dns.Msg)
+			m.SetReply(r)
+			w.WriteMsg(m)
+		}
+	}
+
+	var slowHits, fastHits int32
+	slow := newStubUpstream(t, answer(&slowHits, 50*time.Millisecond))
+	_, port, _ := net.SplitHostPort(slow)
+	fast := newStubUpstreamAt(t, net.JoinHostPort("127.0.0.2", port), answer(&fastHits, 0))
+	useUpstreams(t, slow, fast)
+
+	strategy, err := proxyOrderStrategy(proxyOrderFastest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	picker, err = newResolverPicker(strategy, upstreamAddrs(clientConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first two queries measure each server in turn.
+	for i := 0; i < 12; i++ {
+		m := query(proxyHandler, fmt.Sprintf("q%d.test.", i), dns.TypeA)
+		if m.Rcode != dns.RcodeSuccess {
+			t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[m.Rcode])
+		}
+	}
+
+	if s, f := atomic.LoadInt32(&slowHits), atomic.LoadInt32(&fastHits); s != 1 || f != 11 {
+		t.Fatalf("expected 1 slow and 11 fast queries; actual: %d and %d", s, f)
+	}
+}
+
+func TestProxyOrderStrategy(t *testing.T) {
+	t.Parallel()
+
+	for order, expected := range map[string]string{
+		proxyOrderSequential: strategyFirst,
+		proxyOrderRandom:     strategyRandom,
+		proxyOrderFastest:    strategyLeastLatency,
+	} {
+		actual, err := proxyOrderStrategy(order)
+		if err != nil {
+			t.Errorf("%s: %v", order, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("%s: expected strategy %q; actual: %q", order, expected, actual)
+		}
+	}
+
+	if _, err := proxyOrderStrategy("bogus"); err == nil {
+		t.Error("expected unknown proxy order error")
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// receivedQueries tracks the queries read off the server's sockets until
+// they're answered.
+var receivedQueries wireQueries
+
+// wireQueries maps the remote address of each query read off a socket to the
+// query as it arrived, until it's handled. Each UDP datagram has an address
+// of its own, and a TCP connection's queries are handled one at a time, so
+// the address identifies the query.
+type wireQueries struct {
+	mu sync.Mutex
+	m  map[net.Addr]*wireQuery
+}
+
+// wireQuery is a query read off a socket.
+type wireQuery struct {
+	// wire is the query as received. Outside of the query capture it's the
+	// dns package's read buffer, which is only valid until the query is
+	// handled.
+	wire    []byte
+	handled bool
+}
+
+// received tracks the message b read from addr. Messages shorter than a
+// header are malformed, and the dns package drops them, or answers them with
+// FORMERR, unread, as it does responses, so neither is tracked.
+func (q *wireQueries) received(addr net.Addr, b []byte) {
+	switch {
+	case addr == nil:
+		return
+	case len(b) < dnsHeaderSize:
+		logMalformed(addr, dns.ErrShortRead)
+		return
+	case b[2]&0x80 != 0: // QR
+		return
+	}
+	if capture != nil {
+		b = append([]byte(nil), b...)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.m == nil {
+		q.m = make(map[net.Addr]*wireQuery)
+	}
+	q.m[addr] = &wireQuery{wire: b}
+}
+
+// handling marks the query received from addr as handled.
+func (q *wireQueries) handling(addr net.Addr) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if wq, ok := q.m[addr]; ok {
+		wq.handled = true
+	}
+}
+
+// wire returns the query received from addr while it's captured, or nil.
+func (q *wireQueries) wire(addr net.Addr) []byte {
+	if capture == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if wq, ok := q.m[addr]; ok {
+		return wq.wire
+	}
+
+	return nil
+}
+
+// forget stops tracking the query received from addr. It returns the query
+// if it was never handled.
+func (q *wireQueries) forget(addr net.Addr) ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wq, ok := q.m[addr]
+	if !ok {
+		return nil, false
+	}
+	delete(q.m, addr)
+
+	return wq.wire, !wq.handled
+}
+
+// answeredWriter is a dns.Writer that stops tracking the query it answers.
+// A query answered without being handled is one the dns package couldn't
+// unpack and answered with FORMERR itself, so it's logged and counted as
+// malformed.
+type answeredWriter struct {
+	dns.Writer
+}
+
+// decorateWriter wraps w to stop tracking the queries it answers.
+func decorateWriter(w dns.Writer) dns.Writer {
+	return answeredWriter{w}
+}
+
+func (w answeredWriter) Write(b []byte) (int, error) {
+	if rw, ok := w.Writer.(dns.ResponseWriter); ok {
+		if wire, unhandled := receivedQueries.forget(rw.RemoteAddr()); unhandled {
+			// Only malformed queries pay for unpacking twice.
+			if err := new(dns.Msg).Unpack(wire); err != nil {
+				logMalformed(rw.RemoteAddr(), err)
+			}
+		}
+	}
+
+	return w.Writer.Write(b)
+}
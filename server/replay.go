@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// replayStore holds captured wire-format responses keyed by question name
+// and type, served verbatim in place of generated replies.
+type replayStore map[string][]byte
+
+func replayKey(name string, qtype uint16) string {
+	return strings.ToLower(name) + "/" + dns.TypeToString[qtype]
+}
+
+// loadReplay reads every file in dir as a captured wire-format response,
+// keyed by its first question.
+func loadReplay(dir string) (replayStore, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make(replayStore)
+	for _, fi := range files {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+
+		path := filepath.Join(dir, fi.Name())
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		m := new(dns.Msg)
+		err = m.Unpack(b)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("%s: %s", path, err)
+		case len(m.Question) == 0:
+			return nil, fmt.Errorf("%s: response has no question", path)
+		}
+
+		key := replayKey(m.Question[0].Name, m.Question[0].Qtype)
+		if _, ok := rs[key]; ok {
+			return nil, fmt.Errorf("%s: duplicate response for %s", path, key)
+		}
+		rs[key] = b
+	}
+
+	return rs, nil
+}
+
+// wrap returns a handler writing the captured response matching a request's
+// question, with the request's ID, and otherwise calling h.
+func (rs replayStore) wrap(h func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	if len(rs) == 0 {
+		return h
+	}
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if len(r.Question) != 1 {
+			h(w, r)
+			return
+		}
+		stored, ok := rs[replayKey(r.Question[0].Name, r.Question[0].Qtype)]
+		if !ok {
+			h(w, r)
+			return
+		}
+
+		b := make([]byte, len(stored))
+		copy(b, stored)
+		binary.BigEndian.PutUint16(b, r.Id)
+		r.Rcode = int(b[3] & 0xF)
+
+		responseSize.Observe(float64(len(b)))
+		if _, err := w.Write(b); err != nil {
+			log.Printf("Writing response: %s", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestReplay(t *testing.T) {
+	// A captured response, with a TTL and ordering the JSON loader wouldn't
+	// produce.
+	captured := new(dns.Msg)
+	captured.SetQuestion("Replay.Test.", dns.TypeA)
+	captured.Response, captured.RecursionAvailable = true, true
+	captured.Answer = []dns.RR{
+		mustRR(t, "Replay.Test. 17 IN A 192.0.2.2"),
+		mustRR(t, "Replay.Test. 17 IN A 192.0.2.1"),
+	}
+	stored, err := captured.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err = ioutil.WriteFile(filepath.Join(dir, "replay.bin"), stored, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(v replayStore) { replay = v }(replay)
+	replay, err = loadReplay(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts, _ := NewTestServer(t, loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+
+	conn, err := net.Dial("udp", ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := new(dns.Msg)
+	r.SetQuestion("replay.test.", dns.TypeA)
+	r.Id = 0xBEEF
+	q, err := r.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = conn.Write(q); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	b := make([]byte, dns.MaxMsgSize)
+	n, err := conn.Read(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = b[:n]
+
+	if id := uint16(b[0])<<8 | uint16(b[1]); id != 0xBEEF {
+		t.Errorf("expected ID 0xbeef; actual: %#x", id)
+	}
+	if !bytes.Equal(b[2:], stored[2:]) {
+		t.Errorf("expected captured bytes\n%x\nactual:\n%x", stored[2:], b[2:])
+	}
+
+	// Other questions are answered from the loaded records.
+	ts.AssertAnswer(t, "www.test.com.", dns.TypeA, "www.test.com. 3600 IN A 10.0.0.1")
+}
+
+func TestLoadReplayDuplicate(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := new(dns.Msg)
+	m.SetQuestion("replay.test.", dns.TypeA)
+	b, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.bin", "b.bin"} {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err = loadReplay(dir); err == nil {
+		t.Fatal("expected duplicate response error")
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// rewriteRule replaces the RDATA of answers of a given type matching from
+// with to.
+type rewriteRule struct {
+	rrtype   uint16
+	from, to string
+}
+
+// rewriteRules is a flag.Value of answer rewrite rules, set by repeated
+// TYPE:from=to arguments (e.g. A:10.0.0.1=10.0.0.2).
+type rewriteRules []rewriteRule
+
+func (rules *rewriteRules) String() string {
+	return strings.Join(rules.values(), ",")
+}
+
+func (rules *rewriteRules) values() []string {
+	if rules == nil {
+		return nil
+	}
+
+	s := make([]string, len(*rules))
+	for i, rule := range *rules {
+		s[i] = fmt.Sprintf("%s:%s=%s", dns.TypeToString[rule.rrtype], rule.from, rule.to)
+	}
+
+	return s
+}
+
+func (rules *rewriteRules) Set(s string) error {
+	i := strings.Index(s, ":")
+	j := strings.Index(s, "=")
+	if i < 1 || j < i+2 || j == len(s)-1 {
+		return fmt.Errorf("expected TYPE:from=to; actual: %q", s)
+	}
+
+	rrtype, ok := dns.StringToType[strings.ToUpper(s[:i])]
+	if !ok {
+		return fmt.Errorf("unknown type in rewrite rule %q", s)
+	}
+
+	rule := rewriteRule{rrtype: rrtype, from: s[i+1 : j], to: s[j+1:]}
+	if _, err := dns.NewRR(fmt.Sprintf(". IN %s %s", dns.TypeToString[rrtype], rule.to)); err != nil {
+		return fmt.Errorf("invalid rewrite rule %q: %s", s, err)
+	}
+	*rules = append(*rules, rule)
+
+	return nil
+}
+
+// apply returns a copy of rrs with the rewrite rules applied. The original
+// records are never modified.
+func (rules rewriteRules) apply(rrs []dns.RR) []dns.RR {
+	if len(rules) == 0 || len(rrs) == 0 {
+		return rrs
+	}
+
+	out := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		out[i] = rr
+		hdr := rr.Header()
+		rdata := strings.TrimPrefix(rr.String(), hdr.String())
+
+		for _, rule := range rules {
+			if rule.rrtype != hdr.Rrtype || rule.from != rdata {
+				continue
+			}
+
+			rewritten, err := dns.NewRR(hdr.String() + rule.to)
+			if err != nil {
+				log.Printf("Rewriting %s: %s", rr, err)
+				break
+			}
+			out[i] = rewritten
+			break
+		}
+	}
+
+	return out
+}
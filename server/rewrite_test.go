@@ -0,0 +1,41 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRewriteRules(t *testing.T) {
+	defer func(v rewriteRules) { rewrites = v }(rewrites)
+	rewrites = nil
+
+	if err := rewrites.Set("A:10.0.0.1=10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{"A10.0.0.1=10.0.0.2", "BOGUS:a=b", "A:10.0.0.1=not-an-ip", "A:=10.0.0.2"} {
+		var rules rewriteRules
+		if err := rules.Set(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+
+	d := loadTestData(t, `{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1"},
+		{"hostname": "@", "value": "10.0.0.3"}
+	]}}`)
+
+	m := query(handler(d["test.com."]), "test.com.", dns.TypeA)
+	if len(m.Answer) != 2 {
+		t.Fatalf("expected 2 answers; actual: %v", m.Answer)
+	}
+	for i, expected := range []string{"10.0.0.2", "10.0.0.3"} {
+		if actual := m.Answer[i].(*dns.A).A.String(); actual != expected {
+			t.Errorf("answer %d: expected %s; actual: %s", i, expected, actual)
+		}
+	}
+
+	if actual := d["test.com."].data[dns.TypeA][0].(*dns.A).A.String(); actual != "10.0.0.1" {
+		t.Fatalf("expected stored record unchanged; actual: %s", actual)
+	}
+}
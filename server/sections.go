@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// sectionNames maps the section names accepted in the data file to sections.
+var sectionNames = map[string]section{
+	"answer":     sectionAnswer,
+	"authority":  sectionAuthority,
+	"additional": sectionAdditional,
+	"none":       sectionNone,
+}
+
+// defaultSections places a domain's NS records in the authority section and
+// its addresses in the additional section of every positive answer.
+var defaultSections = map[uint16]section{
+	dns.TypeNS:   sectionAuthority,
+	dns.TypeA:    sectionAdditional,
+	dns.TypeAAAA: sectionAdditional,
+}
+
+// sectionFromMap records the section of positive answers that the domain's
+// records of the type in m are placed in, overriding defaultSections.
+func (recs records) sectionFromMap(m map[string]string) error {
+	typ, ok := dns.StringToType[strings.ToUpper(m[keyType])]
+	if !ok {
+		return fmt.Errorf("%s SECTIONS: invalid type %q", recs.fqdn, m[keyType])
+	}
+	sec, ok := sectionNames[strings.ToLower(m[keyValue])]
+	if !ok {
+		return fmt.Errorf("%s SECTIONS: invalid section %q for %s", recs.fqdn, m[keyValue], dns.TypeToString[typ])
+	}
+	recs.sections[typ] = sec
+
+	return nil
+}
+
+// placement returns the section the domain's records of typ are placed in.
+// Types without a section aren't placed.
+func (recs records) placement(typ uint16) (section, bool) {
+	if sec, ok := recs.sections[typ]; ok {
+		return sec, true
+	}
+	sec, ok := defaultSections[typ]
+
+	return sec, ok
+}
+
+// placedTypes returns the types with a section, NS, A and AAAA first.
+func (recs records) placedTypes() []uint16 {
+	types := []uint16{dns.TypeNS, dns.TypeA, dns.TypeAAAA}
+	var others []uint16
+	for typ := range recs.sections {
+		if _, ok := defaultSections[typ]; !ok {
+			others = append(others, typ)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i] < others[j] })
+
+	return append(types, others...)
+}
+
+// place appends the domain's records to the sections of the positive answer
+// m to r. Records of a queried type are already in the answer section.
+func (recs records) place(r, m *dns.Msg) {
+	for _, typ := range recs.placedTypes() {
+		rrs, ok := recs.data[typ]
+		if !ok {
+			continue
+		}
+
+		sec, _ := recs.placement(typ)
+		switch sec {
+		case sectionAnswer:
+			if !queried(r, typ) {
+				m.Answer = append(m.Answer, rrs...)
+			}
+		case sectionAuthority:
+			m.Ns = append(m.Ns, rrs...)
+		case sectionAdditional:
+			m.Extra = append(m.Extra, rrs...)
+		}
+	}
+}
+
+// queried reports whether r has a question for typ.
+func queried(r *dns.Msg, typ uint16) bool {
+	for _, q := range r.Question {
+		if q.Qtype == typ {
+			return true
+		}
+	}
+
+	return false
+}
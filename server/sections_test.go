@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestSections(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"a": [{"hostname": "www", "value": "10.0.0.1"}],
+		"ns": [{"value": "ns1.test.com."}],
+		"txt": [{"hostname": "www", "value": "hello"}],
+		"sections": [
+			{"type": "NS", "value": "answer"},
+			{"type": "a", "value": "none"}
+		]
+	}}`)
+	h := handler(d["test.com."])
+
+	m := query(h, "www.test.com.", dns.TypeTXT)
+	if actual := rrTypes(m.Answer); len(actual) != 2 || actual[0] != "TXT" || actual[1] != "NS" {
+		t.Errorf("expected TXT and NS answers; actual: %v", actual)
+	}
+	if len(m.Ns) != 0 {
+		t.Errorf("expected empty authority section; actual: %v", m.Ns)
+	}
+	if len(m.Extra) != 0 {
+		t.Errorf("expected A suppressed from additional section; actual: %v", m.Extra)
+	}
+
+	// Records of the queried type aren't repeated.
+	m = query(h, "test.com.", dns.TypeNS)
+	if len(m.Answer) != 1 || len(m.Ns) != 0 {
+		t.Errorf("expected 1 NS answer only; actual: %v %v", m.Answer, m.Ns)
+	}
+
+	for _, j := range []string{
+		`{"test.com.": {"sections": [{"type": "BOGUS", "value": "answer"}]}}`,
+		`{"test.com.": {"sections": [{"type": "NS", "value": "nowhere"}]}}`,
+	} {
+		if err := json.Unmarshal([]byte(j), &d); err == nil {
+			t.Errorf("expected error for %s", j)
+		}
+	}
+}
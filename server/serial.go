@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// serialState tracks the last auto-assigned SOA serial per zone, optionally
+// persisting them to a state file.
+type serialState struct {
+	mu      sync.Mutex
+	path    string
+	serials map[string]uint32
+	now     func() time.Time
+}
+
+func newSerialState(path string) *serialState {
+	return &serialState{
+		path:    path,
+		serials: make(map[string]uint32),
+		now:     time.Now,
+	}
+}
+
+// loadSerialState returns the serial state persisted at path, if any.
+func loadSerialState(path string) (*serialState, error) {
+	s := newSerialState(path)
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, json.Unmarshal(b, &s.serials)
+}
+
+// Next returns the next serial for zone: today's date in YYYYMMDDnn format
+// (nn starting at 00), or the last serial plus one if that's greater.
+func (s *serialState) Next(zone string) (uint32, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	y, m, d := s.now().Date()
+	serial := uint32(y*1000000 + int(m)*10000 + d*100)
+	if last, ok := s.serials[zone]; ok && last >= serial {
+		serial = last + 1
+	}
+	s.serials[zone] = serial
+
+	return serial, s.save()
+}
+
+// save writes the serials to the state file, if there is one.
+func (s *serialState) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.serials, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// autoSerial replaces an "auto" serial in an SOA value with 0, reporting
+// whether it did so.
+func autoSerial(value string) (string, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 || !strings.EqualFold(fields[2], "auto") {
+		return value, false
+	}
+	fields[2] = "0"
+
+	return strings.Join(fields, " "), true
+}
+
+// assignSerials sets the serial of every auto-serial SOA record in d (or
+// every SOA record if -zone-serial is auto) from the zone serial state, and
+// reports whether it set any.
+func assignSerials(d data) bool {
+	var assigned bool
+	for zone, recs := range d {
+		for _, set := range recs.sets() {
+			for _, rr := range set.data[dns.TypeSOA] {
+				if !set.meta[rr].autoSerial && zoneSerial != "auto" {
+					continue
+				}
+
+				serial, err := zoneSerials.Next(zone)
+				if err != nil {
+					log.Printf("Saving serial state: %s", err)
+				}
+				rr.(*dns.SOA).Serial = serial
+				assigned = true
+			}
+		}
+	}
+
+	return assigned
+}
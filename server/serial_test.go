@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const autoSerialFixture = `{"test.com.": {"soa": [{
+	"hostname": "@",
+	"value": "ns1.test.com. hostmaster.test.com. auto 7200 3600 1209600 300"
+}]}}`
+
+func TestAutoSerialReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	today := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.UTC)
+
+	defer func(s *serialState) { zoneSerials = s }(zoneSerials)
+	zoneSerials = newSerialState(path)
+	zoneSerials.now = func() time.Time { return today }
+
+	serial := func(s *Server) uint32 {
+		return s.Snapshot()["test.com."].data[dns.TypeSOA][0].(*dns.SOA).Serial
+	}
+
+	s := NewServer(loadTestData(t, autoSerialFixture))
+	if current := serial(s); current != 0 {
+		t.Fatalf("expected no serial before serving; actual: %d", current)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no state saved before serving; actual: %v", err)
+	}
+
+	s.startServing()
+	last := serial(s)
+	if last != 2026101600 {
+		t.Fatalf("expected initial serial 2026101600; actual: %d", last)
+	}
+
+	for i := 0; i < 2; i++ {
+		s.Reload(loadTestData(t, autoSerialFixture))
+		if current := serial(s); current <= last {
+			t.Fatalf("reload %d: expected serial greater than %d; actual: %d", i+1, last, current)
+		} else {
+			last = current
+		}
+	}
+
+	// A restart picks up from the persisted state rather than going backwards.
+	zoneSerials, err = loadSerialState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zoneSerials.now = func() time.Time { return today }
+
+	s = NewServer(loadTestData(t, autoSerialFixture))
+	s.startServing()
+	if current := serial(s); current != last+1 {
+		t.Fatalf("expected serial %d after restart; actual: %d", last+1, current)
+	}
+}
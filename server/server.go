@@ -0,0 +1,302 @@
+// Package server implements the mockdns DNS server, serving mock records from
+// JSON data and proxying other queries upstream.
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// maxListenAttempts is how many ephemeral ports Listen tries before giving up
+// on finding one free for both UDP and TCP.
+const maxListenAttempts = 5
+
+// Server holds the record data served by mockdns and dispatches requests to
+// the handlers for it.
+type Server struct {
+	mu          sync.RWMutex
+	d           data
+	mux         *dns.ServeMux
+	views       map[string]*dns.ServeMux
+	portViews   map[int]string
+	interceptor func(*dns.Msg) (*dns.Msg, bool)
+	addr        string
+	serving     bool
+}
+
+// NewServer returns a Server serving the records in d. Auto serials aren't
+// assigned until the server listens.
+func NewServer(d data) *Server {
+	addZoneDigests(d)
+
+	mux := dns.NewServeMux()
+	registerHandlers(mux, d)
+
+	return &Server{
+		d:         d,
+		mux:       mux,
+		views:     make(map[string]*dns.ServeMux),
+		portViews: make(map[int]string),
+	}
+}
+
+// NewServerFromBytes returns a Server serving the records in the JSON
+// record data b, such as a file embedded with go:embed.
+func NewServerFromBytes(b []byte) (*Server, error) {
+	return NewServerFromReader(bytes.NewReader(b))
+}
+
+// NewServerFromReader returns a Server serving the JSON record data read
+// from r.
+func NewServerFromReader(r io.Reader) (*Server, error) {
+	d, err := loadData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewServer(d), nil
+}
+
+// Reload replaces the server's records with those in d and discards the
+// proxied replies kept to serve stale.
+func (s *Server) Reload(d data) {
+	assignSerials(d)
+	addZoneDigests(d)
+
+	mux := dns.NewServeMux()
+	registerHandlers(mux, d)
+
+	s.mu.Lock()
+	s.d = d
+	s.mux = mux
+	s.serving = true
+	s.mu.Unlock()
+
+	staleAnswers.reset()
+	reloads.Add(1)
+}
+
+// AddView adds a named view serving the records in d in place of the
+// server's own records.
+func (s *Server) AddView(name string, d data) {
+	addZoneDigests(d)
+
+	mux := dns.NewServeMux()
+	registerHandlers(mux, d)
+
+	s.mu.Lock()
+	s.views[name] = mux
+	s.mu.Unlock()
+}
+
+// RouteSourcePort sends requests from the given client source port to the
+// named view.
+func (s *Server) RouteSourcePort(port int, view string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.views[view]; !ok {
+		return fmt.Errorf("unknown view %q for source port %d", view, port)
+	}
+	s.portViews[port] = view
+
+	return nil
+}
+
+// SetInterceptor registers f to be called with every request before the
+// default handling. If f returns true and a reply, a copy of the reply is
+// sent to the client as-is (with the request's ID), logged like a local
+// answer, and default handling is skipped. The reply itself isn't modified,
+// so f may return the same one to every request. A nil f removes the
+// interceptor.
+func (s *Server) SetInterceptor(f func(*dns.Msg) (*dns.Msg, bool)) {
+	s.mu.Lock()
+	s.interceptor = f
+	s.mu.Unlock()
+}
+
+// startServing assigns the server's auto serials, saving them to the serial
+// state, and updates the zone digests covering them. Only the first call has
+// any effect, so servers that never serve don't advance the saved serials.
+func (s *Server) startServing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.serving {
+		return
+	}
+	s.serving = true
+	if assignSerials(s.d) {
+		addZoneDigests(s.d)
+	}
+}
+
+// Listen binds UDP and TCP sockets on addr for the server. If addr's port is
+// 0, the OS picks a UDP port and TCP binds the same one, retrying with a new
+// port if it's taken. Addr returns the bound address afterward.
+func (s *Server) Listen(addr string) (net.PacketConn, net.Listener, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		bound := pc.LocalAddr().(*net.UDPAddr).Port
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(bound)))
+		if err == nil {
+			s.startServing()
+
+			s.mu.Lock()
+			s.addr = pc.LocalAddr().String()
+			s.mu.Unlock()
+
+			return pc, l, nil
+		}
+		pc.Close()
+
+		if port != "0" || attempt == maxListenAttempts {
+			return nil, nil, err
+		}
+	}
+}
+
+// Addr returns the address the server's sockets are bound to, or an empty
+// string if Listen hasn't succeeded.
+func (s *Server) Addr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.addr
+}
+
+// ServeDNS implements dns.Handler.
+func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	receivedQueries.handling(w.RemoteAddr())
+	defer receivedQueries.forget(w.RemoteAddr())
+
+	s.mu.RLock()
+	f := s.interceptor
+	mux := s.mux
+	if view, ok := s.portViews[sourcePort(w.RemoteAddr())]; ok {
+		mux = s.views[view]
+	}
+	s.mu.RUnlock()
+
+	if len(r.Question) == 0 {
+		logMalformed(w.RemoteAddr(), errors.New("no question"))
+		respond(w, r, dns.RcodeFormatError)
+		return
+	}
+
+	if f != nil {
+		if m, ok := f(r); ok && m != nil {
+			m = m.Copy()
+			logRequest(true, func(w dns.ResponseWriter, r *dns.Msg) {
+				m.Id = r.Id
+				r.Rcode = m.Rcode
+				writeMsg(w, r, m)
+			})(w, r)
+			return
+		}
+	}
+
+	mux.ServeDNS(w, r)
+}
+
+// Snapshot returns a deep copy of the server's current record data.
+func (s *Server) Snapshot() data {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.d.copy()
+}
+
+// copy returns a deep copy of d.
+func (d data) copy() data {
+	c := make(data, len(d))
+	for domain, recs := range d {
+		c[domain] = recs.copy(c)
+	}
+
+	return c
+}
+
+// copy returns a deep copy of recs, including each RR. Since recs.zones holds
+// the data recs belongs to, the copy's zones are set to zones, the copy of
+// that data, rather than copied again.
+func (recs records) copy(zones data) records {
+	c := records{
+		fqdn:        recs.fqdn,
+		parseErrors: recs.parseErrors,
+		typedOnly:   recs.typedOnly,
+		data:        make(map[uint16][]dns.RR, len(recs.data)),
+		meta:        make(map[dns.RR]rrMeta, len(recs.meta)),
+		deny:        make(map[denial]denyRule, len(recs.deny)),
+		alias:       make(map[string]string, len(recs.alias)),
+		sections:    make(map[uint16]section, len(recs.sections)),
+	}
+
+	if recs.zones != nil {
+		c.zones = zones
+	}
+
+	for typ, sec := range recs.sections {
+		c.sections[typ] = sec
+	}
+
+	if recs.byType != nil {
+		c.byType = make(map[uint16]records, len(recs.byType))
+		for qtype, typed := range recs.byType {
+			c.byType[qtype] = typed.copy(zones)
+		}
+	}
+
+	for owner, target := range recs.alias {
+		c.alias[owner] = target
+	}
+
+	for k, rule := range recs.deny {
+		n := atomic.LoadInt64(rule.queries)
+		rule.queries = &n
+		c.deny[k] = rule
+	}
+
+	for typ, rrs := range recs.data {
+		cRRs := make([]dns.RR, len(rrs))
+		for i, rr := range rrs {
+			cRRs[i] = dns.Copy(rr)
+			if meta, ok := recs.meta[rr]; ok {
+				c.meta[cRRs[i]] = meta.copy()
+			}
+		}
+		c.data[typ] = cRRs
+	}
+
+	return c
+}
+
+// copy returns a copy of meta with its own sectionTTL.
+func (meta rrMeta) copy() rrMeta {
+	if meta.sectionTTL != nil {
+		ttls := make(map[section]uint32, len(meta.sectionTTL))
+		for sec, ttl := range meta.sectionTTL {
+			ttls[sec] = ttl
+		}
+		meta.sectionTTL = ttls
+	}
+
+	return meta
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServerSnapshot(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {"a": [
+		{"hostname": "@", "value": "10.0.0.1", "availability": "0.5"}
+	]}}`)
+	s := NewServer(d)
+
+	snap := s.Snapshot()
+
+	orig := d["test.com."]
+	orig.data[dns.TypeA][0].(*dns.A).A = net.IPv4(10, 0, 0, 2)
+	orig.data[dns.TypeA] = append(orig.data[dns.TypeA], &dns.A{A: net.IPv4(10, 0, 0, 3)})
+	orig.data[dns.TypeAAAA] = []dns.RR{&dns.AAAA{AAAA: net.ParseIP("fd00::1")}}
+	delete(d, "test.com.")
+
+	recs, ok := snap["test.com."]
+	if !ok {
+		t.Fatal("expected test.com. in snapshot")
+	}
+	if len(recs.data) != 1 {
+		t.Fatalf("expected only A records in snapshot; actual: %v", recs.data)
+	}
+
+	rrs := recs.data[dns.TypeA]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 A record; actual: %d", len(rrs))
+	}
+	if ip := rrs[0].(*dns.A).A.String(); ip != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1; actual: %s", ip)
+	}
+	if meta, ok := recs.meta[rrs[0]]; !ok || meta.availability != 0.5 {
+		t.Fatalf("expected copied availability 0.5; actual: %v", recs.meta)
+	}
+}
+
+func TestServerSnapshotMutation(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{
+		"test.com.": {"ns": [
+			{"hostname": "@", "value": "ns1.test.com.", "availability": "0.5", "ttl_authority": "5m"}
+		]},
+		"test.com/A": {"a": [{"value": "10.0.0.1"}]}
+	}`)
+	recs := d["test.com."]
+	recs.zones = d
+	d["test.com."] = recs
+	s := NewServer(d)
+
+	snap := s.Snapshot()
+	snapRecs := snap["test.com."]
+	if len(snapRecs.zones) != len(snap) {
+		t.Fatalf("expected the snapshot's zones to be the snapshot; actual: %v", snapRecs.zones)
+	}
+	for rr, meta := range snapRecs.meta {
+		meta.availability = 1
+		meta.sectionTTL[sectionAuthority] = 60
+		snapRecs.meta[rr] = meta
+	}
+	snapRecs.data[dns.TypeNS][0].(*dns.NS).Ns = "ns2.test.com."
+	snapRecs.byType[dns.TypeA].data[dns.TypeA][0].(*dns.A).A = net.IPv4(10, 0, 0, 2)
+	snapRecs.zones["other.com."] = records{}
+
+	if _, ok := d["other.com."]; ok {
+		t.Error("expected the server's zones unchanged")
+	}
+
+	rr := d["test.com."].data[dns.TypeNS][0]
+	if ns := rr.(*dns.NS).Ns; ns != "ns1.test.com." {
+		t.Errorf("expected ns1.test.com.; actual: %s", ns)
+	}
+	meta := d["test.com."].meta[rr]
+	if meta.availability != 0.5 {
+		t.Errorf("expected availability 0.5; actual: %v", meta.availability)
+	}
+	if ttl := meta.sectionTTL[sectionAuthority]; ttl != 300 {
+		t.Errorf("expected authority TTL 300; actual: %d", ttl)
+	}
+
+	a := d["test.com."].byType[dns.TypeA].data[dns.TypeA][0]
+	if ip := a.(*dns.A).A.String(); ip != "10.0.0.1" {
+		t.Errorf("expected 10.0.0.1; actual: %s", ip)
+	}
+}
+
+func TestServerInterceptor(t *testing.T) {
+	defer func(v bool) { localOnly = v }(localOnly)
+	localOnly = true
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	ts, _ := NewTestServer(t, d)
+
+	ts.SetInterceptor(func(r *dns.Msg) (*dns.Msg, bool) {
+		switch r.Question[0].Name {
+		case "intercepted.test.":
+		case "test.com.":
+			return nil, true // no reply, so handled as usual
+		default:
+			return nil, false
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, 1),
+		})
+		return m, true
+	})
+
+	before := scrapeMetrics(t)
+	ts.AssertAnswer(t, "intercepted.test.", dns.TypeA, "intercepted.test. 60 IN A 192.0.2.1")
+	after := scrapeMetrics(t)
+	const count = "mockdns_query_duration_seconds_count"
+	if n := after[count] - before[count]; n != 1 {
+		t.Fatalf("expected the intercepted query measured once; actual: %v", n)
+	}
+
+	ts.AssertAnswer(t, "test.com.", dns.TypeA, "test.com. 3600 IN A 10.0.0.1")
+
+	if m := ts.Exchange(t, "other.test.", dns.TypeA); m.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected unmatched REFUSED; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestServerInterceptorSharedReply(t *testing.T) {
+	t.Parallel()
+
+	shared := new(dns.Msg)
+	shared.Answer = append(shared.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: "shared.test.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.IPv4(192, 0, 2, 1),
+	})
+	expected := shared.String()
+
+	s := NewServer(loadTestData(t, `{}`))
+	s.SetInterceptor(func(*dns.Msg) (*dns.Msg, bool) { return shared, true })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id uint16) {
+			defer wg.Done()
+
+			r := new(dns.Msg)
+			r.SetQuestion("shared.test.", dns.TypeA)
+			r.Id = id
+			r.SetEdns0(1232, true)
+
+			w := new(testResponseWriter)
+			s.ServeDNS(w, r)
+			if w.msg.Id != id || w.msg.IsEdns0() == nil {
+				t.Errorf("expected a reply with ID %d and an OPT record; actual: %v", id, w.msg)
+			}
+		}(uint16(i + 1))
+	}
+	wg.Wait()
+
+	if actual := shared.String(); actual != expected {
+		t.Fatalf("expected the shared reply unmodified\n%s\nactual:\n%s", expected, actual)
+	}
+}
+
+func TestServerSourcePortViews(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`))
+	s.AddView("staging", loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.1.1"}]}}`))
+
+	err := s.RouteSourcePort(5300, "staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.RouteSourcePort(5301, "prod") == nil {
+		t.Fatal("expected unknown view error")
+	}
+
+	for port, expected := range map[int]string{5300: "10.0.1.1", 5302: "10.0.0.1"} {
+		r := new(dns.Msg)
+		r.SetQuestion("test.com.", dns.TypeA)
+
+		w := &testResponseWriter{remote: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}}
+		s.ServeDNS(w, r)
+
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("port %d: expected 1 answer; actual: %v", port, w.msg.Answer)
+		}
+		if actual := w.msg.Answer[0].(*dns.A).A.String(); actual != expected {
+			t.Errorf("port %d: expected %s; actual: %s", port, expected, actual)
+		}
+	}
+}
+
+func TestNewServerFromBytes(t *testing.T) {
+	t.Parallel()
+
+	s, err := NewServerFromBytes([]byte(`{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := query(s.ServeDNS, "www.test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.1" {
+		t.Fatalf("expected www.test.com. A 10.0.0.1; actual: %v", m.Answer)
+	}
+
+	_, err = NewServerFromBytes([]byte(`{"test.com.": [`))
+	if err == nil {
+		t.Fatal("expected error for malformed data")
+	}
+}
+
+// TestServeConcurrently is a regression test for handler registration racing
+// when the TCP and UDP listeners start together; run it with -race.
+func TestServeConcurrently(t *testing.T) {
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	s := NewServer(d)
+
+	pc, l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, server := range []*dns.Server{{Net: "udp", PacketConn: pc}, {Net: "tcp", Listener: l}} {
+		wg.Add(1)
+		go func(server *dns.Server) {
+			serve(ctx, server, s)
+			wg.Done()
+		}(server)
+	}
+
+	// Reloading and adding views while the listeners start must be safe too.
+	s.Reload(loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.2"}]}}`))
+	s.AddView("internal", d)
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	wg.Wait()
+
+	m := query(s.ServeDNS, "test.com.", dns.TypeA)
+	if len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "10.0.0.2" {
+		t.Fatalf("expected reloaded answer; actual: %v", m.Answer)
+	}
+}
+
+func TestServerListenEphemeral(t *testing.T) {
+	s := NewServer(loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`))
+	if addr := s.Addr(); addr != "" {
+		t.Fatalf("expected no address before Listen; actual: %q", addr)
+	}
+
+	pc, l, err := s.Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := s.Addr()
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" || port == "0" {
+		t.Fatalf("expected an ephemeral port on 127.0.0.1; actual: %q", addr)
+	}
+	if l.Addr().String() != addr {
+		t.Fatalf("expected TCP on %s; actual: %s", addr, l.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, server := range []*dns.Server{{Net: "udp", PacketConn: pc}, {Net: "tcp", Listener: l}} {
+		wg.Add(1)
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go func(server *dns.Server) {
+			serve(ctx, server, s)
+			wg.Done()
+		}(server)
+		<-started
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	for _, n := range []string{"udp", "tcp"} {
+		r := new(dns.Msg)
+		r.SetQuestion("www.test.com.", dns.TypeA)
+		m, _, err := (&dns.Client{Net: n, Timeout: time.Second}).Exchange(r, addr)
+		if err != nil {
+			t.Fatalf("%s: %s", n, err)
+		}
+		if len(m.Answer) != 1 {
+			t.Fatalf("%s: expected 1 answer; actual: %v", n, m.Answer)
+		}
+	}
+}
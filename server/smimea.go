@@ -0,0 +1,40 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SMIMEAName returns the SMIMEA owner name for the email address
+// localpart@domain (RFC 8162 3), hashed as OPENPGPKEY owner names are, under
+// _smimecert.domain.
+func SMIMEAName(localpart, domain string) string {
+	return hashedOwnerName(localpart, "_smimecert", domain)
+}
+
+// tlsaFields returns the usage, selector and matching type fields preceding
+// the certificate association data in TLSA-format record data (RFC 6698 2.1),
+// as SMIMEA uses, from the usage, selector and matching_type fields of m.
+func tlsaFields(m map[string]string) (string, error) {
+	fields := make([]string, 0, 3)
+	for _, k := range []string{keyUsage, keySelector, keyMatchingType} {
+		if _, err := strconv.ParseUint(m[k], 10, 8); err != nil {
+			return "", fmt.Errorf("invalid %s %q", k, m[k])
+		}
+		fields = append(fields, m[k])
+	}
+
+	b, err := hex.DecodeString(strings.Replace(m[keyValue], " ", "", -1))
+	if err != nil {
+		return "", fmt.Errorf("invalid hex certificate association data: %s", err)
+	}
+
+	// Matching types 1 and 2 are SHA2-256 and SHA2-512 digests.
+	if size, ok := map[string]int{"1": 32, "2": 64}[fields[2]]; ok && len(b) != size {
+		return "", fmt.Errorf("matching_type %s requires %d bytes of data; got %d", fields[2], size, len(b))
+	}
+
+	return strings.Join(fields, " "), nil
+}
@@ -0,0 +1,13 @@
+package server
+
+import "testing"
+
+func TestSMIMEAName(t *testing.T) {
+	t.Parallel()
+
+	// RFC 8162 3 example for hugh@example.com.
+	expected := "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._smimecert.example.com."
+	if actual := SMIMEAName("Hugh", "example.com"); actual != expected {
+		t.Errorf("expected %q; actual: %q", expected, actual)
+	}
+}
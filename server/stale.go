@@ -0,0 +1,113 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// staleTTL caps the TTLs of stale answers, as RFC 8767 4 recommends.
+	staleTTL = 30
+
+	// staleMaxAge is how long past its expiry an answer may still be served
+	// stale, within the 1 to 3 days RFC 8767 5 recommends.
+	staleMaxAge = 24 * time.Hour
+
+	// staleMaxEntries caps the number of replies kept to serve stale.
+	staleMaxEntries = 10000
+)
+
+// staleEntry is a proxied reply and the time its TTLs expire.
+type staleEntry struct {
+	m       *dns.Msg
+	expires time.Time
+}
+
+// staleCache keeps the last successful proxied reply to each request so it
+// can be served stale once every upstream fails (RFC 8767).
+type staleCache struct {
+	mu      sync.Mutex
+	entries map[string]staleEntry
+}
+
+// store keeps a copy of the reply m to the request identified by key if it's
+// an answer or a name error.
+func (c *staleCache) store(key string, m *dns.Msg, now time.Time) {
+	if m.Rcode != dns.RcodeSuccess && m.Rcode != dns.RcodeNameError {
+		return
+	}
+
+	ttl := uint32(staleMaxAge / time.Second)
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns} {
+		for _, rr := range rrs {
+			if rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]staleEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= staleMaxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = staleEntry{m: m.Copy(), expires: now.Add(time.Duration(ttl) * time.Second)}
+}
+
+// evict removes the entries too old to serve stale, or failing that, the
+// entry expiring soonest. The caller must hold c.mu.
+func (c *staleCache) evict(now time.Time) {
+	var oldest string
+	for key, e := range c.entries {
+		if now.After(e.expires.Add(staleMaxAge)) {
+			delete(c.entries, key)
+			continue
+		}
+		if oldest == "" || e.expires.Before(c.entries[oldest].expires) {
+			oldest = key
+		}
+	}
+	if len(c.entries) >= staleMaxEntries {
+		delete(c.entries, oldest)
+	}
+}
+
+// reset removes every entry.
+func (c *staleCache) reset() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
+
+// lookup returns a copy of the reply stored for key with its TTLs capped at
+// staleTTL, unless it's been expired longer than staleMaxAge.
+func (c *staleCache) lookup(key string, now time.Time) (*dns.Msg, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && now.After(e.expires.Add(staleMaxAge)) {
+		delete(c.entries, key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	m := e.m.Copy()
+	for _, rrs := range [][]dns.RR{m.Answer, m.Ns, m.Extra} {
+		for _, rr := range rrs {
+			// The OPT record's TTL holds its flags.
+			if h := rr.Header(); h.Rrtype != dns.TypeOPT && h.Ttl > staleTTL {
+				h.Ttl = staleTTL
+			}
+		}
+	}
+
+	return m, true
+}
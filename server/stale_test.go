@@ -0,0 +1,117 @@
+package server
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeStale(t *testing.T) {
+	var down int32
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		if atomic.LoadInt32(&down) == 1 {
+			return
+		}
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+			A:   []byte{10, 0, 0, 1},
+		}}
+		w.WriteMsg(m)
+	}))
+	client = &dns.Client{Timeout: 50 * time.Millisecond}
+
+	defer func(v bool) { serveStale = v }(serveStale)
+	serveStale = true
+
+	m := query(proxyHandler, "stale.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 || m.Answer[0].Header().Ttl != 300 {
+		t.Fatalf("expected fresh answer with TTL 300; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+
+	atomic.StoreInt32(&down, 1)
+
+	m = query(proxyHandler, "stale.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Fatalf("expected stale answer; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+	if ttl := m.Answer[0].Header().Ttl; ttl != staleTTL {
+		t.Errorf("expected stale TTL %d; actual: %d", staleTTL, ttl)
+	}
+
+	if m = query(proxyHandler, "fresh.test.", dns.TypeA); m.Rcode != dns.RcodeServerFailure {
+		t.Errorf("expected SERVFAIL without a stale answer; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+
+	serveStale = false
+	if m = query(proxyHandler, "stale.test.", dns.TypeA); m.Rcode != dns.RcodeServerFailure {
+		t.Errorf("expected SERVFAIL with -serve-stale disabled; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+}
+
+func TestStaleCacheMaxAge(t *testing.T) {
+	t.Parallel()
+
+	var c staleCache
+	now := time.Now()
+
+	m := new(dns.Msg)
+	m.SetQuestion("old.test.", dns.TypeA)
+	m.Answer = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "old.test.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   []byte{10, 0, 0, 1},
+	}}
+	c.store("old", m, now)
+
+	if _, ok := c.lookup("old", now.Add(time.Minute+staleMaxAge-time.Second)); !ok {
+		t.Error("expected answer within the maximum stale age")
+	}
+	if _, ok := c.lookup("old", now.Add(time.Minute+staleMaxAge+time.Second)); ok {
+		t.Error("expected no answer past the maximum stale age")
+	}
+}
+
+func TestStaleCacheLimit(t *testing.T) {
+	t.Parallel()
+
+	var c staleCache
+	now := time.Now()
+
+	m := new(dns.Msg)
+	m.SetQuestion("limit.test.", dns.TypeA)
+	c.store("expired", m, now.Add(-3*staleMaxAge))
+	for i := 1; i < staleMaxEntries; i++ {
+		c.store(strconv.Itoa(i), m, now.Add(time.Duration(i)*time.Second))
+	}
+
+	c.store("new", m, now)
+	if len(c.entries) != staleMaxEntries {
+		t.Fatalf("expected %d entries; actual: %d", staleMaxEntries, len(c.entries))
+	}
+	if _, ok := c.entries["expired"]; ok {
+		t.Error("expected the expired entry swept")
+	}
+
+	c.store("newer", m, now.Add(time.Hour))
+	if len(c.entries) != staleMaxEntries {
+		t.Fatalf("expected %d entries; actual: %d", staleMaxEntries, len(c.entries))
+	}
+	if _, ok := c.entries["new"]; ok {
+		t.Error("expected the entry expiring soonest evicted")
+	}
+}
+
+func TestStaleCacheReload(t *testing.T) {
+	m := new(dns.Msg)
+	m.SetQuestion("reload.test.", dns.TypeA)
+	staleAnswers.store("reload", m, time.Now())
+
+	NewServer(loadTestData(t, `{}`)).Reload(loadTestData(t, `{}`))
+	if _, ok := staleAnswers.lookup("reload", time.Now()); ok {
+		t.Error("expected the stale answers discarded on reload")
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TestServer is a Server listening on an ephemeral UDP port for the duration
+// of a test.
+type TestServer struct {
+	*Server
+	client *dns.Client
+}
+
+// NewTestServer starts a server answering from d on an ephemeral port and
+// returns it along with a client for talking to it. Addr returns the port's
+// address. The server is shut down when the test completes.
+func NewTestServer(t *testing.T, d data) (*TestServer, *dns.Client) {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(d)
+	s.startServing()
+	s.addr = pc.LocalAddr().String()
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           s,
+		DecorateReader:    decorateReader,
+		DecorateWriter:    decorateWriter,
+		NotifyStartedFunc: func() { close(started) },
+	}
+
+	go func() {
+		err := server.ActivateAndServe()
+		if err != nil {
+			t.Log(err)
+		}
+	}()
+	<-started
+
+	t.Cleanup(func() {
+		err := server.Shutdown()
+		if err != nil {
+			t.Log(err)
+		}
+	})
+
+	ts := &TestServer{
+		Server: s,
+		client: &dns.Client{Timeout: time.Second},
+	}
+
+	return ts, ts.client
+}
+
+// Exchange sends a query for name and qtype to the server and returns the
+// response, failing the test on error.
+func (ts *TestServer) Exchange(t *testing.T, name string, qtype uint16) *dns.Msg {
+	t.Helper()
+
+	r := new(dns.Msg)
+	r.SetQuestion(name, qtype)
+
+	m, _, err := ts.client.Exchange(r, ts.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// AssertAnswer queries the server for name and qtype and asserts the
+// response succeeds with exactly the given answer records, in order, in
+// zone file format.
+func (ts *TestServer) AssertAnswer(t *testing.T, name string, qtype uint16, want ...string) {
+	t.Helper()
+
+	m := ts.Exchange(t, name, qtype)
+	if m.Rcode != dns.RcodeSuccess {
+		t.Fatalf("expected NOERROR; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if len(m.Answer) != len(want) {
+		t.Fatalf("expected %d answers; actual: %v", len(want), m.Answer)
+	}
+	for i, rr := range m.Answer {
+		if actual := strings.Join(strings.Fields(rr.String()), " "); actual != want[i] {
+			t.Errorf("answer %d: expected %q; actual: %q", i, want[i], actual)
+		}
+	}
+}
+
+// newStubUpstream starts a UDP DNS server answering with h for use as a proxy
+// upstream and returns its address. It's shut down when the test completes.
+func newStubUpstream(t *testing.T, h dns.HandlerFunc) string {
+	t.Helper()
+
+	return newStubUpstreamAt(t, "127.0.0.1:0", h)
+}
+
+// newStubUpstreamAt is newStubUpstream listening on the UDP address addr.
+func newStubUpstreamAt(t *testing.T, addr string, h dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	server := &dns.Server{
+		PacketConn:        pc,
+		Handler:           h,
+		NotifyStartedFunc: func() { close(started) },
+	}
+	go server.ActivateAndServe()
+	<-started
+
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+// useUpstreams points the proxy handler at the given upstream addresses, all
+// of which must share a port, for the duration of the test.
+func useUpstreams(t *testing.T, addrs ...string) {
+	t.Helper()
+
+	cfg := &dns.ClientConfig{}
+	for _, a := range addrs {
+		host, port, err := net.SplitHostPort(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Servers = append(cfg.Servers, host)
+		cfg.Port = port
+	}
+
+	p, c, cc, dc, rp := proxy, client, clientConfig, doh, picker
+	t.Cleanup(func() { proxy, client, clientConfig, doh, picker = p, c, cc, dc, rp })
+
+	proxy, client, clientConfig, doh, picker = true, &dns.Client{Timeout: time.Second}, cfg, nil, nil
+}
+
+func TestNewTestServer(t *testing.T) {
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "www", "value": "10.0.0.1"}]}}`)
+
+	ts, client := NewTestServer(t, d)
+	if client == nil {
+		t.Fatal("expected client")
+	}
+
+	ts.AssertAnswer(t, "www.test.com.", dns.TypeA, "www.test.com. 3600 IN A 10.0.0.1")
+}
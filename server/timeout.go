@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var errQueryAnswered = errors.New("query already answered")
+
+// timedResponseWriter lets a handler and a deadline race to answer a query,
+// writing only whichever reply comes first.
+type timedResponseWriter struct {
+	dns.ResponseWriter
+
+	mu      sync.Mutex
+	done    bool
+	expired bool
+}
+
+// WriteMsg writes m unless the query was already answered.
+func (w *timedResponseWriter) WriteMsg(m *dns.Msg) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return errQueryAnswered
+	}
+	w.done = true
+
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// Write writes b unless the query was already answered.
+func (w *timedResponseWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.done {
+		return 0, errQueryAnswered
+	}
+	w.done = true
+
+	return w.ResponseWriter.Write(b)
+}
+
+// expire stops any further writes, waiting for one in progress to complete.
+// It reports whether the query was still unanswered.
+func (w *timedResponseWriter) expire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	answered := w.done
+	w.done, w.expired = true, true
+
+	return !answered
+}
+
+// withQueryTimeout answers r with SERVFAIL if f hasn't answered it within
+// timeout. f keeps running on a copy of r, but its late reply is discarded.
+// Otherwise, r's rcode mirrors the one f set on the copy.
+func withQueryTimeout(timeout time.Duration, f func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	if timeout <= 0 {
+		return f
+	}
+
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		tw := &timedResponseWriter{ResponseWriter: w}
+		rc := r.Copy()
+		done := make(chan struct{})
+		go func() {
+			f(tw, rc)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if tw.expire() {
+				log.Printf("Warning: %s not answered within %s; answered SERVFAIL", flightKey(r), timeout)
+				respond(w, r, dns.RcodeServerFailure)
+				return
+			}
+			// f answered just in time.
+			<-done
+		}
+		r.Rcode = rc.Rcode
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestQueryTimeout(t *testing.T) {
+	t.Parallel()
+
+	late := make(chan error, 1)
+	slow := func(w dns.ResponseWriter, r *dns.Msg) {
+		time.Sleep(200 * time.Millisecond)
+		m := new(dns.Msg)
+		m.SetReply(r)
+		late <- w.WriteMsg(m)
+	}
+
+	r := new(dns.Msg)
+	r.SetQuestion("slow.test.", dns.TypeA)
+	r.SetEdns0(1232, false)
+	w := new(testResponseWriter)
+	withQueryTimeout(50*time.Millisecond, slow)(w, r)
+
+	m := w.msg
+	if m.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+	if r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL mirrored to the request; actual: %s", dns.RcodeToString[r.Rcode])
+	}
+	if len(m.Question) != 1 || m.Question[0].Name != "slow.test." {
+		t.Fatalf("expected question to be echoed; actual: %v", m.Question)
+	}
+	if m.IsEdns0() == nil {
+		t.Fatal("expected OPT record to be echoed")
+	}
+	if err := <-late; err != errQueryAnswered {
+		t.Fatalf("expected late reply to be discarded; actual error: %v", err)
+	}
+
+	m = query(withQueryTimeout(time.Second, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		r.Rcode = dns.RcodeNameError
+		w.WriteMsg(m)
+	}), "fast.test.", dns.TypeA)
+	if m.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// dotTLSConfig returns the TLS configuration for the DNS-over-TLS listener
+// (RFC 7858) serving the given certificate and key. If clientCA is set,
+// clients must present a certificate signed by it, or, if optional, may
+// present none at all.
+func dotTLSConfig(certFile, keyFile, clientCA string, optional bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA == "" {
+		return cfg, nil
+	}
+
+	b, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %q", clientCA)
+	}
+
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if optional {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return cfg, nil
+}
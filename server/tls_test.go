@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testCert is a certificate and key signed by a parent, or self-signed if
+// the parent is nil.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write saves the certificate and key as PEM files in dir, returning their
+// paths.
+func (c *testCert) write(t *testing.T, dir, name string) (string, string) {
+	t.Helper()
+
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	for path, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: c.der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		err = ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestDoTClientCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mockdns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "test CA", nil, true)
+	rogueCA := newTestCert(t, "rogue CA", nil, true)
+	server := newTestCert(t, "127.0.0.1", ca, false)
+	good := newTestCert(t, "good client", ca, false)
+	rogue := newTestCert(t, "rogue client", rogueCA, false)
+
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := server.write(t, dir, "server")
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	s := NewServer(d)
+
+	for _, optional := range []bool{false, true} {
+		cfg, err := dotTLSConfig(certFile, keyFile, caFile, optional)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := tls.Listen("tcp", "127.0.0.1:0", cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		started := make(chan struct{})
+		srv := &dns.Server{Listener: l, Handler: s, NotifyStartedFunc: func() { close(started) }}
+		go srv.ActivateAndServe()
+		<-started
+
+		for _, c := range []struct {
+			name    string
+			certs   []tls.Certificate
+			succeed bool
+		}{
+			{"CA-signed client", []tls.Certificate{good.tlsCertificate()}, true},
+			{"rogue client", []tls.Certificate{rogue.tlsCertificate()}, false},
+			{"anonymous client", nil, optional},
+		} {
+			certs := c.certs
+			client := &dns.Client{
+				Net:     "tcp-tls",
+				Timeout: time.Second,
+				TLSConfig: &tls.Config{
+					RootCAs: roots,
+					// Present the certificate even if the server doesn't
+					// list its issuer as acceptable.
+					GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+						if len(certs) == 0 {
+							return new(tls.Certificate), nil
+						}
+						return &certs[0], nil
+					},
+				},
+			}
+
+			r := new(dns.Msg)
+			r.SetQuestion("test.com.", dns.TypeA)
+			m, _, err := client.Exchange(r, l.Addr().String())
+			switch {
+			case c.succeed && err != nil:
+				t.Errorf("optional=%t: %s: %s", optional, c.name, err)
+			case c.succeed && len(m.Answer) != 1:
+				t.Errorf("optional=%t: %s: expected answer; actual: %v", optional, c.name, m.Answer)
+			case !c.succeed && err == nil:
+				t.Errorf("optional=%t: %s: expected handshake error", optional, c.name)
+			}
+		}
+
+		srv.Shutdown()
+	}
+}
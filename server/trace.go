@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Span attribute keys, following the OpenTelemetry semantic conventions where
+// they exist.
+const (
+	attrHandler   = "mockdns.handler"
+	attrQName     = "dns.question.name"
+	attrQType     = "dns.question.type"
+	attrOutcome   = "dns.response.code"
+	attrUpstream  = "server.address"
+	attrRequestID = "mockdns.request_id"
+)
+
+// span records the handling of a single query, modeled on an OpenTelemetry
+// server span.
+type span struct {
+	TraceID    string
+	SpanID     string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+}
+
+// spanExporter receives finished spans. It must be safe for concurrent use.
+type spanExporter interface {
+	ExportSpan(*span)
+}
+
+// tracer exports a span per query when set. Tracing is a no-op otherwise.
+var tracer spanExporter
+
+// startSpan begins a span for the first question in r.
+func startSpan(r *dns.Msg, handler string) *span {
+	s := &span{
+		TraceID:    randomID(16),
+		SpanID:     randomID(8),
+		Name:       "dns.query",
+		Start:      time.Now(),
+		Attributes: map[string]string{attrHandler: handler},
+	}
+	if len(r.Question) > 0 {
+		s.Attributes[attrQName] = r.Question[0].Name
+		s.Attributes[attrQType] = dns.TypeToString[r.Question[0].Qtype]
+	}
+
+	return s
+}
+
+func randomID(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// tracedWriter carries the span for a request to the handlers answering it.
+type tracedWriter struct {
+	dns.ResponseWriter
+	span *span
+}
+
+// setSpanAttribute sets an attribute on the span for the request written to
+// w, if it's being traced.
+func setSpanAttribute(w dns.ResponseWriter, key, value string) {
+	switch tw := w.(type) {
+	case *tracedWriter:
+		tw.span.Attributes[key] = value
+	case *timedResponseWriter:
+		// The span may already be exported if the query timed out.
+		tw.mu.Lock()
+		if !tw.expired {
+			setSpanAttribute(tw.ResponseWriter, key, value)
+		}
+		tw.mu.Unlock()
+	}
+}
+
+// otlpExporter batches spans and POSTs them as OTLP/HTTP JSON to an endpoint
+// such as http://localhost:4318/v1/traces.
+type otlpExporter struct {
+	endpoint string
+	client   *http.Client
+	spans    chan *span
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		spans:    make(chan *span, 1024),
+	}
+}
+
+// ExportSpan queues s for export, dropping it if the queue is full.
+func (e *otlpExporter) ExportSpan(s *span) {
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+// run exports queued spans in batches until ctx is done, then flushes any
+// remaining spans.
+func (e *otlpExporter) run(ctx context.Context) {
+	const maxBatch = 256
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var batch []*span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.post(batch)
+		if err != nil {
+			log.Printf("Exporting spans: %s", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-e.spans:
+			batch = append(batch, s)
+			if len(batch) >= maxBatch {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case s := <-e.spans:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	out := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		out[i].Key = k
+		out[i].Value.StringValue = attrs[k]
+	}
+
+	return out
+}
+
+// post sends spans to the endpoint in an OTLP ExportTraceServiceRequest.
+func (e *otlpExporter) post(spans []*span) error {
+	type otlpSpan struct {
+		TraceID    string          `json:"traceId"`
+		SpanID     string          `json:"spanId"`
+		Name       string          `json:"name"`
+		Kind       int             `json:"kind"`
+		Start      string          `json:"startTimeUnixNano"`
+		End        string          `json:"endTimeUnixNano"`
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	out := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		out[i] = otlpSpan{
+			TraceID:    s.TraceID,
+			SpanID:     s.SpanID,
+			Name:       s.Name,
+			Kind:       2, // SPAN_KIND_SERVER
+			Start:      strconv.FormatInt(s.Start.UnixNano(), 10),
+			End:        strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes: otlpAttributes(s.Attributes),
+		}
+	}
+
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": "mockdns"}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "mockdns"},
+				"spans": out,
+			}},
+		}},
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", e.endpoint, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// memoryExporter is a spanExporter keeping spans in memory.
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*span
+}
+
+func (e *memoryExporter) ExportSpan(s *span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+func TestQuerySpans(t *testing.T) {
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(m)
+	}))
+
+	exp := new(memoryExporter)
+	defer func(v spanExporter) { tracer = v }(tracer)
+	tracer = exp
+
+	d := loadTestData(t, `{"test.com.": {"a": [{"hostname": "@", "value": "10.0.0.1"}]}}`)
+	query(logRequest(true, handler(d["test.com."])), "test.com.", dns.TypeA)
+	query(logRequest(false, proxyHandler), "missing.test.", dns.TypeAAAA)
+
+	if len(exp.spans) != 2 {
+		t.Fatalf("expected a span per query; actual: %d", len(exp.spans))
+	}
+
+	for i, expected := range []map[string]string{
+		{attrHandler: "local", attrQName: "test.com.", attrQType: "A", attrOutcome: "NOERROR"},
+		{attrHandler: "proxied", attrQName: "missing.test.", attrQType: "AAAA", attrOutcome: "NXDOMAIN",
+			attrUpstream: clientConfig.Servers[0] + ":" + clientConfig.Port},
+	} {
+		s := exp.spans[i]
+		if len(s.Attributes) != len(expected) {
+			t.Errorf("span %d: expected attributes %v; actual: %v", i, expected, s.Attributes)
+			continue
+		}
+		for k, v := range expected {
+			if s.Attributes[k] != v {
+				t.Errorf("span %d: expected %s=%q; actual: %q", i, k, v, s.Attributes[k])
+			}
+		}
+		if len(s.TraceID) != 32 || len(s.SpanID) != 16 {
+			t.Errorf("span %d: invalid IDs %q/%q", i, s.TraceID, s.SpanID)
+		}
+		if s.End.Before(s.Start) {
+			t.Errorf("span %d: ends before it starts", i)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	t.Parallel()
+
+	bodies := make(chan []byte, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		bodies <- b
+	}))
+	defer ts.Close()
+
+	exp := newOTLPExporter(ts.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exp.run(ctx)
+		close(done)
+	}()
+
+	r := new(dns.Msg)
+	r.SetQuestion("test.com.", dns.TypeA)
+	s := startSpan(r, "local")
+	s.End = time.Now()
+	exp.ExportSpan(s)
+
+	cancel()
+	<-done
+
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID    string          `json:"traceId"`
+					Name       string          `json:"name"`
+					Attributes []otlpAttribute `json:"attributes"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	err := json.Unmarshal(<-bodies, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].TraceID != s.TraceID || spans[0].Name != "dns.query" {
+		t.Fatalf("expected exported span %s; actual: %+v", s.TraceID, spans)
+	}
+	if len(spans[0].Attributes) != 3 {
+		t.Fatalf("expected 3 attributes; actual: %+v", spans[0].Attributes)
+	}
+}
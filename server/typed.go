@@ -0,0 +1,59 @@
+package server
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// splitDomainKey splits a data file domain key of the form "domain/TYPE",
+// whose records answer only queries of that type, into the domain and type.
+// Keys whose last "/" isn't followed by a type name, such as RFC 2317
+// classless delegation names like "0/25.2.0.192.in-addr.arpa.", are plain
+// domains and return a zero type.
+func splitDomainKey(key string) (string, uint16) {
+	i := strings.LastIndex(key, "/")
+	if i < 0 || strings.Contains(key[i+1:], ".") {
+		return key, 0
+	}
+
+	qtype, ok := dns.StringToType[strings.ToUpper(key[i+1:])]
+	if !ok {
+		return key, 0
+	}
+
+	return key[:i], qtype
+}
+
+// sets returns recs followed by its "domain/TYPE" record sets, in type
+// order.
+func (recs records) sets() []records {
+	qtypes := make([]int, 0, len(recs.byType))
+	for qtype := range recs.byType {
+		qtypes = append(qtypes, int(qtype))
+	}
+	sort.Ints(qtypes)
+
+	sets := make([]records, 0, 1+len(qtypes))
+	sets = append(sets, recs)
+	for _, qtype := range qtypes {
+		sets = append(sets, recs.byType[uint16(qtype)])
+	}
+
+	return sets
+}
+
+// typeHandler dispatches queries to the handler for their type in handlers,
+// if any, and all others to next.
+func typeHandler(handlers map[uint16]func(dns.ResponseWriter, *dns.Msg), next func(dns.ResponseWriter, *dns.Msg)) func(dns.ResponseWriter, *dns.Msg) {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		if len(r.Question) > 0 {
+			if h, ok := handlers[r.Question[0].Qtype]; ok {
+				h(w, r)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestTypedDomainKeys(t *testing.T) {
+	useUpstreams(t, newStubUpstream(t, func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(10, 9, 9, 9),
+		}}
+		w.WriteMsg(m)
+	}))
+
+	ts, _ := NewTestServer(t, loadTestData(t, `{
+		"example.com/A": {"a": [{"value": "10.0.0.2"}]},
+		"example.com": {
+			"a": [{"value": "10.0.0.1"}],
+			"txt": [{"value": "base"}]
+		},
+		"typed.test/aaaa": {"aaaa": [{"ttl": "60", "value": "2001:db8::1"}]}
+	}`))
+
+	ts.AssertAnswer(t, "example.com.", dns.TypeA, "example.com. 3600 IN A 10.0.0.2")
+	ts.AssertAnswer(t, "example.com.", dns.TypeTXT, `example.com. 3600 IN TXT "base"`)
+	ts.AssertAnswer(t, "typed.test.", dns.TypeAAAA, "typed.test. 60 IN AAAA 2001:db8::1")
+
+	// Other types for a domain with only typed keys are proxied.
+	ts.AssertAnswer(t, "typed.test.", dns.TypeA, "typed.test. 60 IN A 10.9.9.9")
+
+	// An RFC 2317 classless delegation name is a plain domain.
+	ts, _ = NewTestServer(t, loadTestData(t, `{
+		"0/25.2.0.192.in-addr.arpa": {"ptr": [{"value": "host.example."}]}
+	}`))
+	ts.AssertAnswer(t, "0/25.2.0.192.in-addr.arpa.", dns.TypePTR,
+		"0/25.2.0.192.in-addr.arpa. 3600 IN PTR host.example.")
+
+	d := make(data)
+	if err := json.Unmarshal([]byte(`{"example.com/BOGUS": {}}`), &d); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d["example.com/bogus."]; !ok {
+		t.Errorf("expected an unknown type suffix kept in the domain; actual: %v", d)
+	}
+
+	d = loadTestData(t, `{
+		"example.com": {"a": [{"value": "10.0.0.1"}]},
+		"example.com/aaaa": {"aaaa": [{"value": "2001:db8::1"}]}
+	}`)
+	if s := summarize(d, nil); s.Records != 2 {
+		t.Errorf("expected typed records counted; actual: %d", s.Records)
+	}
+}
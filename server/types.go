@@ -0,0 +1,747 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+type data map[string]records
+
+func (d data) UnmarshalJSON(b []byte) error {
+	if d == nil {
+		return errNilMapUnmarshal
+	}
+
+	var m map[string]json.RawMessage
+
+	err := json.Unmarshal(b, &m)
+	if err == nil {
+		for key, j := range m {
+			domain, qtype := splitDomainKey(key)
+			if !preserveCase {
+				domain = strings.ToLower(domain)
+			}
+			domain = dns.Fqdn(domain)
+
+			rt := records{
+				fqdn: domain,
+				data: make(map[uint16][]dns.RR),
+			}
+			uErr := json.Unmarshal(j, &rt)
+			if uErr != nil && !noFatalParse {
+				return uErr
+			}
+			if uErr != nil {
+				warn("skipping invalid domain %s: %s", domain, uErr)
+				rt.parseErrors++
+				parseErrors.Add(domain, 1)
+				if !noProxyOnError {
+					// Proxy the domain as if it weren't in the data file.
+					continue
+				}
+			}
+
+			vErr := warnOrFail(checkApexCNAME(rt))
+			if vErr != nil {
+				return vErr
+			}
+			vErr = warnOrFail(checkAliasCNAME(rt))
+			if vErr != nil {
+				return vErr
+			}
+
+			base, ok := d[domain]
+			if qtype != 0 {
+				if !ok {
+					base = records{fqdn: domain, data: make(map[uint16][]dns.RR), typedOnly: true}
+				}
+				if base.byType == nil {
+					base.byType = make(map[uint16]records)
+				}
+				base.byType[qtype] = rt
+				d[domain] = base
+				continue
+			}
+
+			rt.byType = base.byType
+			d[domain] = rt
+		}
+
+		for _, gErr := range checkGlue(d) {
+			vErr := warnOrFail(gErr)
+			if vErr != nil {
+				return vErr
+			}
+		}
+	}
+
+	return err
+}
+
+type records struct {
+	fqdn string
+	data map[uint16][]dns.RR
+	meta map[dns.RR]rrMeta
+	deny map[denial]denyRule
+
+	// alias maps lowercased owner names to ALIAS targets.
+	alias map[string]string
+
+	// sections overrides the section each type's records are placed in.
+	sections map[uint16]section
+
+	// parseErrors counts the entries skipped under -no-fatal-parse.
+	parseErrors int
+
+	// byType holds the records of "domain/TYPE" keys, answering queries of
+	// that type in place of these records.
+	byType map[uint16]records
+
+	// typedOnly indicates the domain only appears in "domain/TYPE" keys, so
+	// queries of other types are proxied.
+	typedOnly bool
+
+	// zones holds every domain served alongside this one, for glue.
+	zones data
+}
+
+// denial identifies a name and type answered with a fixed rcode.
+type denial struct {
+	name  string
+	qtype uint16
+}
+
+// denyRule is the rcode answering a denied name and type. When after or until
+// is set, the rcode is only answered after, or until, that many queries.
+type denyRule struct {
+	rcode        int
+	after, until int64
+
+	// queries counts the queries for the name and type, shared by copies of
+	// the rule.
+	queries *int64
+}
+
+// rrMeta holds per-record serving behavior that isn't part of the RR itself.
+type rrMeta struct {
+	// availability is the probability (0.0-1.0) the record is served.
+	availability float64
+
+	// autoSerial indicates the SOA serial is assigned by mockdns.
+	autoSerial bool
+
+	// weight biases the record's position under weighted answer ordering.
+	weight float64
+
+	// sectionTTL overrides the record's TTL when it's placed in the
+	// authority or additional section.
+	sectionTTL map[section]uint32
+}
+
+// section identifies a message section.
+type section int
+
+const (
+	sectionAuthority section = iota
+	sectionAdditional
+	sectionAnswer
+	sectionNone
+)
+
+func (recs *records) UnmarshalJSON(b []byte) error {
+	if recs.data == nil {
+		recs.data = make(map[uint16][]dns.RR)
+	}
+	if recs.meta == nil {
+		recs.meta = make(map[dns.RR]rrMeta)
+	}
+	if recs.deny == nil {
+		recs.deny = make(map[denial]denyRule)
+	}
+	if recs.alias == nil {
+		recs.alias = make(map[string]string)
+	}
+	if recs.sections == nil {
+		recs.sections = make(map[uint16]section)
+	}
+
+	var m map[string][]map[string]json.RawMessage
+	err := json.Unmarshal(b, &m)
+	if err == nil {
+		for typ, v := range m {
+			typ = strings.ToUpper(typ)
+			if alias, ok := typeAliases[typ]; ok {
+				typ = alias
+			}
+			if typ == "SECTIONS" || typ == "ALIAS" {
+				for _, raw := range v {
+					pErr := recs.skipOrFail(recs.settingFromMap(typ, raw))
+					if pErr != nil {
+						return pErr
+					}
+				}
+				continue
+			}
+
+			iType, ok := supportedTypes[typ]
+			if !ok {
+				warn("skipping unsupported type %q for %s", typ, recs.fqdn)
+				continue
+			}
+
+			for _, raw := range v {
+				pErr := recs.skipOrFail(recs.recordFromMap(typ, iType, raw))
+				if pErr != nil {
+					return pErr
+				}
+			}
+		}
+
+		// Mail clients try MX hosts in ascending preference order (RFC 5321 5.1).
+		mx := recs.data[dns.TypeMX]
+		sort.SliceStable(mx, func(i, j int) bool {
+			return mx[i].(*dns.MX).Preference < mx[j].(*dns.MX).Preference
+		})
+	}
+
+	return err
+}
+
+// settingFromMap applies a SECTIONS or ALIAS entry read from the data file.
+func (recs records) settingFromMap(typ string, raw map[string]json.RawMessage) error {
+	r, strs, err := recordFields(raw)
+	if err == nil && strs != nil {
+		err = fmt.Errorf("%s %s: value must be a string", recs.fqdn, typ)
+	}
+	if err != nil {
+		return err
+	}
+
+	if typ == "SECTIONS" {
+		return recs.sectionFromMap(r)
+	}
+
+	return recs.aliasFromMap(r)
+}
+
+// recordFromMap adds the record of type typ read from the data file, or the
+// denial it describes, to recs.
+func (recs records) recordFromMap(typ string, iType uint16, raw map[string]json.RawMessage) error {
+	r, strs, err := recordFields(raw)
+	if err != nil {
+		return err
+	}
+	if strs != nil && typ != "TXT" {
+		return fmt.Errorf("%s %s: value must be a string", recs.fqdn, typ)
+	}
+
+	if rcode, ok := r[keyDeny]; ok {
+		return recs.denyFromMap(iType, r, rcode)
+	}
+
+	rr, err := recs.rrFromMap(typ, recs.fqdn, r)
+	if err != nil {
+		return err
+	}
+	if rr == nil {
+		return nil
+	}
+	if strs != nil {
+		err = setTXTStrings(rr, strs)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recs.metaFromMap(rr, r)
+	if err != nil {
+		return err
+	}
+	recs.data[iType] = append(recs.data[iType], rr)
+
+	return nil
+}
+
+// skipOrFail returns err unless -no-fatal-parse is set, in which case it logs
+// err and counts it against the domain so the invalid entry is skipped.
+func (recs *records) skipOrFail(err error) error {
+	if err == nil || !noFatalParse {
+		return err
+	}
+	warn("skipping invalid record in %s: %s", recs.fqdn, err)
+	recs.parseErrors++
+	parseErrors.Add(recs.fqdn, 1)
+
+	return nil
+}
+
+// recordFields returns the fields of a record read from the data file. Every
+// field is a string, except that a value may instead be an array of strings,
+// which is returned separately.
+func recordFields(raw map[string]json.RawMessage) (map[string]string, []string, error) {
+	m := make(map[string]string, len(raw))
+	var strs []string
+	for k, v := range raw {
+		var s string
+		err := json.Unmarshal(v, &s)
+		if err == nil {
+			m[k] = s
+			continue
+		}
+		if k != keyValue || json.Unmarshal(v, &strs) != nil || strs == nil {
+			return nil, nil, err
+		}
+	}
+
+	return m, strs, nil
+}
+
+// ownerName returns the owner name for the record map m in zone fqdn.
+func ownerName(fqdn string, m map[string]string) string {
+	v, ok := m[keyHostname]
+	switch {
+	case !ok, v == "@": // wildcard host name
+		return fqdn
+	case dns.IsFqdn(v): // absolute names are used as-is
+		return v
+	}
+
+	return fmt.Sprintf("%s.%s", v, fqdn)
+}
+
+// denyFromMap records that queries for qtype at the owner name in m are
+// answered with the named rcode, optionally only after or until a number of
+// queries.
+func (recs records) denyFromMap(qtype uint16, m map[string]string, name string) error {
+	rcode, ok := dns.StringToRcode[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("invalid deny rcode %q for %s", name, recs.fqdn)
+	}
+	rule := denyRule{rcode: rcode, queries: new(int64)}
+
+	for key, n := range map[string]*int64{keyDenyAfter: &rule.after, keyDenyUntil: &rule.until} {
+		v, ok := m[key]
+		if !ok {
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || i < 1 {
+			return fmt.Errorf("invalid %s %q for %s", key, v, recs.fqdn)
+		}
+		*n = i
+	}
+	if rule.after > 0 && rule.until > 0 {
+		return fmt.Errorf("%s: %s and %s are mutually exclusive", recs.fqdn, keyDenyAfter, keyDenyUntil)
+	}
+	recs.deny[denial{strings.ToLower(ownerName(recs.fqdn, m)), qtype}] = rule
+
+	return nil
+}
+
+// denied returns the rcode answering queries for name and qtype, if any.
+// Each call counts as a query against a rule limited by after or until.
+func (recs records) denied(name string, qtype uint16) (int, bool) {
+	rule, ok := recs.deny[denial{strings.ToLower(name), qtype}]
+	switch {
+	case !ok:
+		return 0, false
+	case rule.after > 0:
+		return rule.rcode, atomic.AddInt64(rule.queries, 1) > rule.after
+	case rule.until > 0:
+		return rule.rcode, atomic.AddInt64(rule.queries, 1) <= rule.until
+	}
+
+	return rule.rcode, true
+}
+
+func (recs records) rrFromMap(typ, fqdn string, m map[string]string) (dns.RR, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	parts := []string{ownerName(fqdn, m)}
+
+	ttl := defaultTTL
+	if v, ok := m[keyTTL]; ok {
+		ttl = v
+	}
+	secs, err := parseTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+	parts = append(parts, strconv.FormatUint(uint64(secs), 10))
+
+	parts = append(parts, "IN", typ)
+	if typ == "ZONEMD" {
+		parts[len(parts)-1] = fmt.Sprintf("TYPE%d", typeZONEMD)
+	}
+
+	if typ == "MX" { // priority only support for MX records
+		if v, ok := m[keyPriority]; ok {
+			parts = append(parts, v)
+		}
+	}
+
+	if typ == "CERT" {
+		v, err := certFields(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s CERT: %s", parts[0], err)
+		}
+		parts = append(parts, v)
+	}
+
+	if typ == "SMIMEA" {
+		v, err := tlsaFields(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s SMIMEA: %s", parts[0], err)
+		}
+		parts = append(parts, v)
+	}
+
+	if typ == "CSYNC" {
+		v, err := csyncFields(m)
+		if err != nil {
+			return nil, fmt.Errorf("%s CSYNC: %s", parts[0], err)
+		}
+		parts = append(parts, v)
+	}
+
+	if _, ok := m[keyLat]; ok && typ == "LOC" {
+		if _, ok := m[keyValue]; !ok {
+			v, err := locValue(m)
+			if err != nil {
+				return nil, fmt.Errorf("%s LOC: %s", parts[0], err)
+			}
+			parts = append(parts, v)
+		}
+	}
+
+	if v, ok := m[keyValue]; ok {
+		switch typ {
+		case "TXT":
+			v = splitTXT(v)
+		case "SOA":
+			v, _ = autoSerial(v)
+		case "ZONEMD":
+			var err error
+			if v, err = zonemdValue(v); err != nil {
+				return nil, fmt.Errorf("%s ZONEMD: %s", parts[0], err)
+			}
+		case "EUI48", "EUI64":
+			octets := map[string]int{"EUI48": 6, "EUI64": 8}[typ]
+			if err := checkEUI(v, octets); err != nil {
+				return nil, fmt.Errorf("%s %s: %s", parts[0], typ, err)
+			}
+		}
+		parts = append(parts, v)
+	}
+
+	if rr := addressRR(typ, parts[0], secs, m[keyValue]); rr != nil {
+		return rr, nil
+	}
+
+	s := strings.Join(parts, " ")
+	rr, err := dns.NewRR(s)
+
+	if err != nil {
+		log.Printf("Attempting to parse: %q", s)
+	}
+
+	return rr, err
+}
+
+// addressRR returns the A or AAAA record for owner and value built directly,
+// sparing the zone file parser behind dns.NewRR, which dominates loading large
+// data files. It returns nil if typ isn't A or AAAA, or if owner or value need
+// the parser's handling (or its errors).
+func addressRR(typ, owner string, ttl uint32, value string) dns.RR {
+	if typ != "A" && typ != "AAAA" || !plainName(owner) {
+		return nil
+	}
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil
+	}
+
+	hdr := dns.RR_Header{Name: owner, Rrtype: supportedTypes[typ], Class: dns.ClassINET, Ttl: ttl}
+	if typ == "A" {
+		return &dns.A{Hdr: hdr, A: ip}
+	}
+
+	return &dns.AAAA{Hdr: hdr, AAAA: ip}
+}
+
+// plainName reports whether name is a valid domain name of only letters,
+// digits, hyphens, underscores, asterisks and dots, which the zone file
+// parser takes as-is.
+func plainName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-', c == '_', c == '*', c == '.':
+		default:
+			return false
+		}
+	}
+	_, ok := dns.IsDomainName(name)
+
+	return ok
+}
+
+// lookup returns the records owned by name matching qtype, or the CNAME owned
+// by name if there are none, along with whether name exists in the zone.
+func (recs records) lookup(name string, qtype uint16) ([]dns.RR, bool) {
+	types := make([]int, 0, len(recs.data))
+	for typ := range recs.data {
+		types = append(types, int(typ))
+	}
+	sort.Ints(types)
+
+	var exists bool
+	var rrs, cnames []dns.RR
+	for _, typ := range types {
+		for _, rr := range recs.data[uint16(typ)] {
+			owner := rr.Header().Name
+			if !strings.EqualFold(owner, name) {
+				// An empty non-terminal exists if it has descendants.
+				exists = exists || strings.HasSuffix(strings.ToLower(owner), "."+strings.ToLower(name))
+				continue
+			}
+			exists = true
+
+			switch {
+			case qtype == dns.TypeANY, qtype == uint16(typ):
+				rrs = append(rrs, rr)
+			case uint16(typ) == dns.TypeCNAME:
+				cnames = append(cnames, rr)
+			}
+		}
+	}
+
+	if len(rrs) == 0 {
+		rrs = cnames
+	}
+
+	return rrs, exists
+}
+
+// negativeSOA returns the zone's SOA record for the authority section of a
+// negative response, with its TTL set to the lesser of the SOA's TTL and
+// minimum field per RFC 2308 3. It returns nil if the zone has no SOA.
+func (recs records) negativeSOA() []dns.RR {
+	rrs := recs.data[dns.TypeSOA]
+	if len(rrs) == 0 {
+		return nil
+	}
+
+	soa := dns.Copy(rrs[0]).(*dns.SOA)
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+
+	return []dns.RR{soa}
+}
+
+// parseTTL converts a TTL given in seconds, or as a Go or BIND style duration
+// made up of w, d, h, m, and s units (e.g., "1h30m" or "1W2D"), to seconds.
+func parseTTL(v string) (uint32, error) {
+	if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+
+	var secs, n uint64
+	var digits bool
+	for _, c := range strings.ToLower(v) {
+		if c >= '0' && c <= '9' {
+			n = n*10 + uint64(c-'0')
+			digits = true
+			if n > math.MaxUint32 {
+				return 0, fmt.Errorf("TTL %q out of range", v)
+			}
+			continue
+		}
+
+		unit, ok := ttlUnits[c]
+		if !ok || !digits {
+			return 0, fmt.Errorf("invalid TTL %q", v)
+		}
+		secs += n * unit
+		n, digits = 0, false
+	}
+
+	if v == "" || digits || secs > math.MaxUint32 {
+		return 0, fmt.Errorf("invalid TTL %q", v)
+	}
+
+	return uint32(secs), nil
+}
+
+// splitTXT breaks a TXT value into quoted character-strings of at most 255
+// bytes each (RFC 1035 3.3.14), separated by spaces. It splits between UTF-8
+// characters rather than within one.
+func splitTXT(value string) string {
+	const maxLen = 255
+
+	var parts []string
+	for len(value) > maxLen {
+		n := maxLen
+		for n > 0 && !utf8.RuneStart(value[n]) {
+			n--
+		}
+		if n == 0 { // not UTF-8
+			n = maxLen
+		}
+		parts = append(parts, fmt.Sprintf("%q", value[:n]))
+		value = value[n:]
+	}
+	parts = append(parts, fmt.Sprintf("%q", value))
+
+	return strings.Join(parts, " ")
+}
+
+// setTXTStrings sets the character-strings of the TXT record rr to strs
+// verbatim, rather than chunking a single value as splitTXT does.
+func setTXTStrings(rr dns.RR, strs []string) error {
+	if len(strs) == 0 {
+		return fmt.Errorf("%s TXT: value array is empty", rr.Header().Name)
+	}
+	for _, s := range strs {
+		if len(s) > 255 {
+			return fmt.Errorf("%s TXT: character-string exceeds 255 bytes: %q", rr.Header().Name, s)
+		}
+	}
+	rr.(*dns.TXT).Txt = strs
+
+	return nil
+}
+
+func (recs records) metaFromMap(rr dns.RR, m map[string]string) error {
+	meta := rrMeta{availability: 1, weight: 1}
+	var ok bool
+
+	if v, found := m[keyAvailability]; found {
+		p, err := strconv.ParseFloat(v, 64)
+		if err != nil || p < 0 || p > 1 {
+			return fmt.Errorf("invalid availability %q for %s", v, rr.Header().Name)
+		}
+		meta.availability = p
+		ok = true
+	}
+
+	if v, found := m[keyWeight]; found {
+		w, err := strconv.ParseFloat(v, 64)
+		if err != nil || w <= 0 || math.IsInf(w, 0) {
+			return fmt.Errorf("invalid weight %q for %s", v, rr.Header().Name)
+		}
+		meta.weight = w
+		ok = true
+	}
+
+	for key, sec := range map[string]section{
+		keyTTLAuthority:  sectionAuthority,
+		keyTTLAdditional: sectionAdditional,
+	} {
+		v, found := m[key]
+		if !found {
+			continue
+		}
+		ttl, err := parseTTL(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q for %s: %s", key, v, rr.Header().Name, err)
+		}
+		if meta.sectionTTL == nil {
+			meta.sectionTTL = make(map[section]uint32)
+		}
+		meta.sectionTTL[sec] = ttl
+		ok = true
+	}
+
+	_, auto := autoSerial(m[keyValue])
+	if (auto || m[keySerial] == "auto") && rr.Header().Rrtype == dns.TypeSOA {
+		meta.autoSerial = true
+		ok = true
+	}
+
+	if ok {
+		recs.meta[rr] = meta
+	}
+
+	return nil
+}
+
+// metaFor returns the metadata of rr from recs or any zone served alongside
+// it.
+func (recs records) metaFor(rr dns.RR) (rrMeta, bool) {
+	if meta, ok := recs.meta[rr]; ok {
+		return meta, true
+	}
+	for _, zone := range recs.zones {
+		if meta, ok := zone.meta[rr]; ok {
+			return meta, true
+		}
+	}
+
+	return rrMeta{}, false
+}
+
+// inSection returns rrs with the TTL overrides for sec applied to copies of
+// the records having them.
+func (recs records) inSection(rrs []dns.RR, sec section) []dns.RR {
+	var out []dns.RR
+	for i, rr := range rrs {
+		meta, _ := recs.metaFor(rr)
+		ttl, ok := meta.sectionTTL[sec]
+		if !ok {
+			continue
+		}
+
+		if out == nil {
+			out = make([]dns.RR, len(rrs))
+			copy(out, rrs)
+		}
+		out[i] = dns.Copy(rr)
+		out[i].Header().Ttl = ttl
+	}
+
+	if out == nil {
+		return rrs
+	}
+
+	return out
+}
+
+// weight returns the answer ordering weight of rr, which defaults to 1.
+func (recs records) weight(rr dns.RR) float64 {
+	if meta, ok := recs.meta[rr]; ok {
+		return meta.weight
+	}
+
+	return 1
+}
+
+// available returns the subset of rrs served for the current query, rolling
+// each record with an availability below 1.0 against the shared random number
+// generator.
+func (recs records) available(rrs []dns.RR) []dns.RR {
+	var out []dns.RR
+	for _, rr := range rrs {
+		if meta, ok := recs.meta[rr]; ok && meta.availability < 1 {
+			if randFloat64() >= meta.availability {
+				continue
+			}
+		}
+		out = append(out, rr)
+	}
+
+	return out
+}
@@ -0,0 +1,507 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/miekg/dns"
+)
+
+func TestDataUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	b, err := ioutil.ReadFile("../example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := make(data)
+	err = json.Unmarshal(b, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDataUnmarshalNilData(t *testing.T) {
+	t.Parallel()
+
+	var d data
+	b := []byte(`{"test.com.": {}}`)
+	err := json.Unmarshal(b, &d)
+	if err != errNilMapUnmarshal {
+		t.Fatalf("expected nil map error; actual: %s", err)
+	}
+}
+
+func TestDataUnmarshalNilRecs(t *testing.T) {
+	t.Parallel()
+
+	b := []byte(`{"test.com.": {}}`)
+	d := make(data)
+	err := json.Unmarshal(b, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNilRRFromNilMap(t *testing.T) {
+	var m map[string]string
+	var recs records
+
+	rr, err := recs.rrFromMap("A", "test.com.", m)
+	if rr != nil || err != nil {
+		t.Fatalf("expected nil, nil; actual: %v, %s", rr, err)
+	}
+}
+
+func TestLongTXTSplit(t *testing.T) {
+	t.Parallel()
+
+	value := strings.Repeat("a", 600)
+	m := map[string]string{keyValue: value}
+
+	var recs records
+	rr, err := recs.rrFromMap("TXT", "test.com.", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txt, ok := rr.(*dns.TXT)
+	if !ok {
+		t.Fatalf("expected *dns.TXT; actual: %T", rr)
+	}
+	if len(txt.Txt) != 3 {
+		t.Fatalf("expected 3 character-strings; actual: %d", len(txt.Txt))
+	}
+	if actual := strings.Join(txt.Txt, ""); actual != value {
+		t.Fatalf("expected reassembled value %q; actual: %q", value, actual)
+	}
+}
+
+func TestLongTXTSplitUTF8(t *testing.T) {
+	t.Parallel()
+
+	value := strings.Repeat("é", 300)
+	m := map[string]string{keyValue: value}
+
+	var recs records
+	rr, err := recs.rrFromMap("TXT", "test.com.", m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txt := rr.(*dns.TXT)
+	for i, s := range txt.Txt {
+		if len(s) > 255 || !utf8.ValidString(s) {
+			t.Errorf("character-string %d: expected at most 255 bytes of whole characters; actual: %d bytes %q", i, len(s), s)
+		}
+	}
+	if actual := strings.Join(txt.Txt, ""); actual != value {
+		t.Fatalf("expected reassembled value %q; actual: %q", value, actual)
+	}
+}
+
+func TestTXTStringArray(t *testing.T) {
+	t.Parallel()
+
+	first, second := "v=DKIM1; k=rsa; p="+strings.Repeat("A", 200), strings.Repeat("B", 60)
+	d := loadTestData(t, fmt.Sprintf(`{"test.com.": {"txt": [{"hostname": "sel._domainkey", "value": [%q, %q]}]}}`,
+		first, second))
+
+	txt := d["test.com."].data[dns.TypeTXT][0].(*dns.TXT)
+	if len(txt.Txt) != 2 || txt.Txt[0] != first || txt.Txt[1] != second {
+		t.Fatalf("expected character-strings %q and %q; actual: %q", first, second, txt.Txt)
+	}
+
+	for _, bad := range []string{
+		`{"txt": [{"hostname": "@", "value": []}]}`,
+		`{"txt": [{"hostname": "@", "value": ["` + strings.Repeat("a", 256) + `"]}]}`,
+		`{"txt": [{"hostname": "@", "value": [1]}]}`,
+		`{"a": [{"hostname": "@", "value": ["10.0.0.1"]}]}`,
+	} {
+		err := json.Unmarshal([]byte(`{"test.com.": `+bad+`}`), &d)
+		if err == nil {
+			t.Errorf("%.60s: expected error", bad)
+		}
+	}
+}
+
+func TestTypeAliases(t *testing.T) {
+	b := []byte(`{"test.com.": {
+		"spf": [{"hostname": "@", "value": "v=spf1 -all"}],
+		"bogus": [{"hostname": "@", "value": "nope"}]
+	}}`)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	d := make(data)
+	err := json.Unmarshal(b, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rrs := d["test.com."].data[dns.TypeTXT]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 TXT record; actual: %d", len(rrs))
+	}
+	if _, ok := rrs[0].(*dns.TXT); !ok {
+		t.Fatalf("expected *dns.TXT; actual: %T", rrs[0])
+	}
+
+	if !strings.Contains(buf.String(), `"BOGUS"`) {
+		t.Fatalf("expected warning naming BOGUS type; actual: %q", buf.String())
+	}
+}
+
+func TestAbsoluteHostname(t *testing.T) {
+	t.Parallel()
+
+	var recs records
+	for hostname, expected := range map[string]string{
+		"@":                  "test.com.",
+		"www":                "www.test.com.",
+		"www.test.com.":      "www.test.com.",
+		"ns1.other.example.": "ns1.other.example.",
+	} {
+		rr, err := recs.rrFromMap("A", "test.com.", map[string]string{
+			keyHostname: hostname,
+			keyValue:    "10.0.0.1",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := rr.Header().Name; actual != expected {
+			t.Errorf("hostname %q: expected owner %q; actual: %q", hostname, expected, actual)
+		}
+	}
+}
+
+func TestHumanReadableTTL(t *testing.T) {
+	t.Parallel()
+
+	var recs records
+	for ttl, expected := range map[string]uint32{
+		"1h":   3600,
+		"30m":  1800,
+		"90s":  90,
+		"3600": 3600,
+		"1h5s": 3605,
+		"1W1d": 691200,
+	} {
+		rr, err := recs.rrFromMap("A", "test.com.", map[string]string{
+			keyTTL:   ttl,
+			keyValue: "10.0.0.1",
+		})
+		if err != nil {
+			t.Fatalf("TTL %q: %s", ttl, err)
+		}
+		if actual := rr.Header().Ttl; actual != expected {
+			t.Errorf("TTL %q: expected %d; actual: %d", ttl, expected, actual)
+		}
+	}
+
+	for _, ttl := range []string{"", "h", "1x", "1.5h", "-1"} {
+		_, err := recs.rrFromMap("A", "test.com.", map[string]string{
+			keyTTL:   ttl,
+			keyValue: "10.0.0.1",
+		})
+		if err == nil {
+			t.Errorf("TTL %q: expected error", ttl)
+		}
+	}
+}
+
+func TestLOCDecimalDegrees(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {"loc": [
+		{"hostname": "@", "lat": "37.3861", "lon": "-122.0825", "altitude": "30"}
+	]}}`)
+
+	rrs := d["test.com."].data[dns.TypeLOC]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 LOC record; actual: %v", rrs)
+	}
+	loc := rrs[0].(*dns.LOC)
+
+	// LOC stores thousandths of an arcsecond offset from the equator and
+	// prime meridian.
+	if expected := uint32(dns.LOC_EQUATOR + 134589960); loc.Latitude != expected {
+		t.Errorf("expected latitude %d; actual: %d", expected, loc.Latitude)
+	}
+	if expected := uint32(dns.LOC_PRIMEMERIDIAN - 439497000); loc.Longitude != expected {
+		t.Errorf("expected longitude %d; actual: %d", expected, loc.Longitude)
+	}
+	if expected := uint32(dns.LOC_ALTITUDEBASE*100 + 3000); loc.Altitude != expected {
+		t.Errorf("expected altitude %d; actual: %d", expected, loc.Altitude)
+	}
+
+	_, err := dms("91", 90, "N", "S")
+	if err == nil {
+		t.Fatal("expected out of range latitude error")
+	}
+}
+
+func TestCERT(t *testing.T) {
+	t.Parallel()
+
+	der := []byte{0x30, 0x82, 0x01, 0x0a, 0x02, 0x82, 0x01, 0x01, 0x00, 0xc4, 0xff, 0x10}
+	d := loadTestData(t, fmt.Sprintf(`{"test.com.": {"cert": [{
+		"hostname": "smime", "cert_type": "pkix", "key_tag": "12345", "algorithm": "RSASHA256", "value": %q
+	}]}}`, base64.StdEncoding.EncodeToString(der)))
+
+	rrs := d["test.com."].data[dns.TypeCERT]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 CERT record; actual: %v", rrs)
+	}
+	cert := rrs[0].(*dns.CERT)
+	if cert.Type != dns.CertPKIX || cert.KeyTag != 12345 || cert.Algorithm != dns.RSASHA256 {
+		t.Errorf("unexpected CERT fields: %v", cert)
+	}
+
+	actual, err := base64.StdEncoding.DecodeString(cert.Certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, der) {
+		t.Fatalf("expected certificate %x; actual: %x", der, actual)
+	}
+
+	for _, bad := range []string{
+		`{"hostname": "@", "cert_type": "BOGUS", "value": "AAAA"}`,
+		`{"hostname": "@", "cert_type": "PKIX", "key_tag": "70000", "value": "AAAA"}`,
+		`{"hostname": "@", "cert_type": "PKIX", "value": "not base64!"}`,
+	} {
+		err := json.Unmarshal([]byte(`{"test.com.": {"cert": [`+bad+`]}}`), &d)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestOPENPGPKEY(t *testing.T) {
+	t.Parallel()
+
+	key := []byte{0x99, 0x01, 0x0d, 0x04, 0x5a, 0x1b, 0x2c, 0x3d}
+	owner := strings.TrimSuffix(OpenPGPKeyName("hugh", "test.com"), ".test.com.")
+	d := loadTestData(t, fmt.Sprintf(`{"test.com.": {"openpgpkey": [{"hostname": %q, "value": %q}]}}`,
+		owner, base64.StdEncoding.EncodeToString(key)))
+
+	rrs := d["test.com."].data[dns.TypeOPENPGPKEY]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 OPENPGPKEY record; actual: %v", rrs)
+	}
+	rr := rrs[0].(*dns.OPENPGPKEY)
+	if expected := "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._openpgpkey.test.com."; rr.Hdr.Name != expected {
+		t.Errorf("expected owner %q; actual: %q", expected, rr.Hdr.Name)
+	}
+
+	actual, err := base64.StdEncoding.DecodeString(rr.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, key) {
+		t.Fatalf("expected key %x; actual: %x", key, actual)
+	}
+}
+
+func TestSMIMEA(t *testing.T) {
+	t.Parallel()
+
+	digest := strings.Repeat("ab", 32)
+	d := loadTestData(t, fmt.Sprintf(`{"test.com.": {"smimea": [{
+		"hostname": %q, "usage": "3", "selector": "1", "matching_type": "1", "value": %q
+	}]}}`, strings.TrimSuffix(SMIMEAName("hugh", "test.com"), ".test.com."), digest))
+
+	rrs := d["test.com."].data[dns.TypeSMIMEA]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 SMIMEA record; actual: %v", rrs)
+	}
+	rr := rrs[0].(*dns.SMIMEA)
+	if rr.Usage != 3 || rr.Selector != 1 || rr.MatchingType != 1 || rr.Certificate != digest {
+		t.Errorf("unexpected SMIMEA fields: %v", rr)
+	}
+
+	for _, bad := range []string{
+		`{"hostname": "@", "usage": "256", "selector": "1", "matching_type": "0", "value": "ab"}`,
+		`{"hostname": "@", "usage": "3", "matching_type": "0", "value": "ab"}`,
+		`{"hostname": "@", "usage": "3", "selector": "1", "matching_type": "0", "value": "xyz"}`,
+		`{"hostname": "@", "usage": "3", "selector": "1", "matching_type": "1", "value": "abcd"}`,
+	} {
+		err := json.Unmarshal([]byte(`{"test.com.": {"smimea": [`+bad+`]}}`), &d)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestCSYNC(t *testing.T) {
+	t.Parallel()
+
+	// RFC 7477 3: example.com. 3600 IN CSYNC 66 3 A NS AAAA
+	d := loadTestData(t, `{"example.com.": {"csync": [{
+		"ttl": "3600", "serial": "66", "flags": "3", "type_bitmap": "A NS aaaa"
+	}]}}`)
+
+	rrs := d["example.com."].data[dns.TypeCSYNC]
+	if len(rrs) != 1 {
+		t.Fatalf("expected 1 CSYNC record; actual: %v", rrs)
+	}
+	if expected, actual := "example.com.\t3600\tIN\tCSYNC\t66 3 A NS AAAA", rrs[0].String(); actual != expected {
+		t.Errorf("expected %q; actual: %q", expected, actual)
+	}
+	rr := rrs[0].(*dns.CSYNC)
+	if rr.Serial != 66 || rr.Flags != csyncImmediate|csyncSOAMinimum {
+		t.Errorf("unexpected CSYNC fields: %v", rr)
+	}
+
+	for _, bad := range []string{
+		`{"serial": "x", "flags": "0", "type_bitmap": "A"}`,
+		`{"serial": "66", "flags": "4", "type_bitmap": "A"}`,
+		`{"serial": "66", "flags": "0", "type_bitmap": "A BOGUS"}`,
+	} {
+		err := json.Unmarshal([]byte(`{"example.com.": {"csync": [`+bad+`]}}`), &d)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestEUI(t *testing.T) {
+	t.Parallel()
+
+	d := loadTestData(t, `{"test.com.": {
+		"eui48": [{"hostname": "host", "value": "00-00-5e-00-53-2a"}],
+		"eui64": [{"hostname": "host", "value": "00-00-5E-EF-10-00-00-2A"}]
+	}}`)
+
+	eui48 := d["test.com."].data[dns.TypeEUI48][0].(*dns.EUI48)
+	if eui48.Address != 0x00005e00532a {
+		t.Errorf("expected EUI48 address 0x00005e00532a; actual: %#x", eui48.Address)
+	}
+	eui64 := d["test.com."].data[dns.TypeEUI64][0].(*dns.EUI64)
+	if eui64.Address != 0x00005eef1000002a {
+		t.Errorf("expected EUI64 address 0x00005eef1000002a; actual: %#x", eui64.Address)
+	}
+
+	for _, bad := range []string{
+		`{"eui48": [{"hostname": "@", "value": "00:00:5e:00:53:2a"}]}`,
+		`{"eui48": [{"hostname": "@", "value": "00-00-5e-00-53"}]}`,
+		`{"eui48": [{"hostname": "@", "value": "00-00-5e-00-53-zz"}]}`,
+		`{"eui48": [{"hostname": "@", "value": "00-00-5e-00-53-2a-00-00"}]}`,
+		`{"eui64": [{"hostname": "@", "value": "00-00-5e-00-53-2a"}]}`,
+		`{"eui64": [{"hostname": "@", "value": "00-00-5e-ef-10-00-00-2"}]}`,
+	} {
+		err := json.Unmarshal([]byte(`{"test.com.": `+bad+`}`), &d)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestNoFatalParse(t *testing.T) {
+	defer func(fatal, proxy bool) { noFatalParse, noProxyOnError = fatal, proxy }(noFatalParse, noProxyOnError)
+
+	const j = `{
+		"parse.test.": {
+			"a": [
+				{"hostname": "www", "value": "10.0.0.1"},
+				{"hostname": "bad", "value": "not-an-address"}
+			],
+			"txt": [{"hostname": "www", "value": ["ok", 1]}]
+		},
+		"broken.test.": "not a domain"
+	}`
+
+	d := make(data)
+	if err := json.Unmarshal([]byte(j), &d); err == nil {
+		t.Fatal("expected parse error without -no-fatal-parse")
+	}
+
+	noFatalParse = true
+	before := scrapeMetrics(t)
+	d = loadTestData(t, j)
+	if _, ok := d["broken.test."]; ok {
+		t.Error("expected invalid domain to be skipped")
+	}
+	h := handler(d["parse.test."])
+	if m := query(h, "www.parse.test.", dns.TypeA); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Errorf("expected valid record to load; actual: %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+	if m := query(h, "bad.parse.test.", dns.TypeA); m.Rcode != dns.RcodeNameError {
+		t.Errorf("expected NXDOMAIN for skipped record; actual: %s", dns.RcodeToString[m.Rcode])
+	}
+
+	after := scrapeMetrics(t)
+	for domain, expected := range map[string]float64{"parse.test.": 2, "broken.test.": 1} {
+		key := fmt.Sprintf("mockdns_parse_errors_total{domain=%q}", domain)
+		if n := after[key] - before[key]; n != expected {
+			t.Errorf("expected %s to increase by %v; actual: %v", key, expected, n)
+		}
+	}
+
+	noProxyOnError = true
+	d = loadTestData(t, j)
+	for _, tc := range []struct {
+		domain, name string
+		expected     int
+	}{
+		{"parse.test.", "www.parse.test.", dns.RcodeSuccess},
+		{"parse.test.", "bad.parse.test.", dns.RcodeServerFailure},
+		{"broken.test.", "www.broken.test.", dns.RcodeServerFailure},
+	} {
+		recs, ok := d[tc.domain]
+		if !ok {
+			t.Errorf("expected %s to be served locally", tc.domain)
+			continue
+		}
+		if m := query(handler(recs), tc.name, dns.TypeA); m.Rcode != tc.expected {
+			t.Errorf("%s: expected %s; actual: %s", tc.name, dns.RcodeToString[tc.expected], dns.RcodeToString[m.Rcode])
+		}
+	}
+}
+
+func TestAddressRRMatchesParser(t *testing.T) {
+	t.Parallel()
+
+	var recs records
+	for _, c := range []struct {
+		typ, hostname, value string
+		direct               bool
+	}{
+		{"A", "www", "10.0.0.1", true},
+		{"A", "*", "10.0.0.2", true},
+		{"AAAA", "_srv.WWW", "2001:db8::1", true},
+		{"A", `a\.b`, "10.0.0.3", false},
+		{"A", "www", " 10.0.0.4", false},
+		{"CNAME", "mail", "www.test.com.", false},
+	} {
+		m := map[string]string{"hostname": c.hostname, "ttl": "300", "value": c.value}
+		owner := ownerName("test.com.", m)
+		if rr := addressRR(c.typ, owner, 300, c.value); (rr != nil) != c.direct {
+			t.Errorf("%s %s: expected direct record %t; actual: %v", owner, c.typ, c.direct, rr)
+		}
+
+		rr, err := recs.rrFromMap(c.typ, "test.com.", m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := dns.NewRR(fmt.Sprintf("%s 300 IN %s %s", owner, c.typ, c.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !dns.IsDuplicate(rr, expected) || rr.Header().Name != expected.Header().Name || rr.Header().Ttl != 300 {
+			t.Errorf("expected %v; actual: %v", expected, rr)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// checkApexCNAME returns an error if recs has a CNAME at the zone apex
+// alongside NS or SOA records, which RFC 2181 10.1 prohibits.
+func checkApexCNAME(recs records) error {
+	var cname bool
+	for _, rr := range recs.data[dns.TypeCNAME] {
+		if rr.Header().Name == recs.fqdn {
+			cname = true
+			break
+		}
+	}
+	if !cname {
+		return nil
+	}
+
+	for _, typ := range []uint16{dns.TypeNS, dns.TypeSOA} {
+		for _, rr := range recs.data[typ] {
+			if rr.Header().Name == recs.fqdn {
+				return fmt.Errorf("%s: CNAME at zone apex alongside %s record (RFC 2181 10.1)",
+					recs.fqdn, dns.TypeToString[typ])
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkGlue returns an error for each in-zone NS target lacking an A or AAAA
+// record anywhere in d. Out-of-zone targets are exempt.
+func checkGlue(d data) []error {
+	domains := make([]string, 0, len(d))
+	for domain := range d {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+
+	var errs []error
+	for _, domain := range domains {
+		for _, set := range d[domain].sets() {
+			for _, rr := range set.data[dns.TypeNS] {
+				target := rr.(*dns.NS).Ns
+				if !dns.IsSubDomain(domain, target) || d.hasAddress(target) {
+					continue
+				}
+				errs = append(errs, fmt.Errorf("%s: NS target %s has no A or AAAA glue record",
+					domain, target))
+			}
+		}
+	}
+
+	return errs
+}
+
+// hasAddress reports whether any domain in d has an A or AAAA record owned by
+// name.
+func (d data) hasAddress(name string) bool {
+	return len(d.addresses(name)) > 0
+}
+
+// addresses returns the A and AAAA records owned by name in any domain in d.
+func (d data) addresses(name string) []dns.RR {
+	var rrs []dns.RR
+	for _, recs := range d {
+		for _, set := range recs.sets() {
+			for _, typ := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				for _, rr := range set.data[typ] {
+					if strings.EqualFold(rr.Header().Name, name) {
+						rrs = append(rrs, rr)
+					}
+				}
+			}
+		}
+	}
+
+	return rrs
+}
+
+// dryRunWarnings collects the warnings logged by warn during -dry-run.
+var dryRunWarnings []string
+
+// warn logs a warning about the data being loaded, collecting it for the
+// -dry-run summary.
+func warn(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	log.Printf("Warning: %s", msg)
+	if dryRun {
+		dryRunWarnings = append(dryRunWarnings, msg)
+	}
+}
+
+// warnOrFail logs err as a warning, or returns it in strict mode.
+func warnOrFail(err error) error {
+	if err == nil || strict {
+		return err
+	}
+	warn("%s", err)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+const apexCNAMEFixture = `{"test.com.": {
+	"cname": [{"hostname": "@", "value": "other.example."}],
+	"ns": [{"hostname": "@", "value": "ns1.other.example."}]
+}}`
+
+func TestApexCNAMEWarning(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	d := make(data)
+	err := json.Unmarshal([]byte(apexCNAMEFixture), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), "test.com.: CNAME at zone apex") {
+		t.Fatalf("expected apex CNAME warning; actual: %q", buf.String())
+	}
+}
+
+func TestApexCNAMEStrict(t *testing.T) {
+	defer func(v bool) { strict = v }(strict)
+	strict = true
+
+	d := make(data)
+	err := json.Unmarshal([]byte(apexCNAMEFixture), &d)
+	if err == nil {
+		t.Fatal("expected apex CNAME error in strict mode")
+	}
+}
+
+func TestCheckGlue(t *testing.T) {
+	d := loadTestData(t, `{
+		"test.com.": {
+			"ns": [
+				{"hostname": "@", "value": "ns1.test.com."},
+				{"hostname": "@", "value": "ns2.test.com."},
+				{"hostname": "@", "value": "ns.other.example."}
+			],
+			"a": [{"hostname": "ns2", "value": "10.0.0.2"}]
+		}
+	}`)
+
+	errs := checkGlue(d)
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 glue error; actual: %v", errs)
+	}
+	if !strings.Contains(errs[0].Error(), "ns1.test.com.") {
+		t.Fatalf("expected error for ns1.test.com.; actual: %s", errs[0])
+	}
+}
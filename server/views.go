@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// viewFiles is a flag.Value mapping view names to data files, set by
+// repeated name=path arguments.
+type viewFiles map[string]string
+
+func (v viewFiles) String() string {
+	return strings.Join(v.values(), ",")
+}
+
+func (v viewFiles) values() []string {
+	names := make([]string, 0, len(v))
+	for name := range v {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + v[name]
+	}
+
+	return pairs
+}
+
+func (v viewFiles) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 || i == len(s)-1 {
+		return fmt.Errorf("expected name=path; actual: %q", s)
+	}
+	v[s[:i]] = s[i+1:]
+
+	return nil
+}
+
+// portViews is a flag.Value mapping client source ports to view names, set
+// by a comma-separated list of port:view pairs.
+type portViews map[int]string
+
+func (p portViews) String() string {
+	ports := make([]int, 0, len(p))
+	for port := range p {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	pairs := make([]string, len(ports))
+	for i, port := range ports {
+		pairs[i] = fmt.Sprintf("%d:%s", port, p[port])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (p portViews) Set(s string) error {
+	for _, pair := range strings.Split(s, ",") {
+		i := strings.Index(pair, ":")
+		if i < 0 || i == len(pair)-1 {
+			return fmt.Errorf("expected port:view; actual: %q", pair)
+		}
+
+		port, err := strconv.Atoi(pair[:i])
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port in %q", pair)
+		}
+		p[port] = pair[i+1:]
+	}
+
+	return nil
+}
+
+// sourcePort returns the port of a UDP or TCP address, or 0 for any other
+// address.
+func sourcePort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.Port
+	case *net.TCPAddr:
+		return a.Port
+	}
+
+	return 0
+}
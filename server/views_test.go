@@ -0,0 +1,25 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestPortViewsFlag(t *testing.T) {
+	t.Parallel()
+
+	p := make(portViews)
+	err := p.Set("5300:staging,5301:prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p[5300] != "staging" || p[5301] != "prod" {
+		t.Fatalf("expected parsed port map; actual: %v", p)
+	}
+	if actual := p.String(); actual != "5300:staging,5301:prod" {
+		t.Fatalf("expected round trip; actual: %q", actual)
+	}
+
+	if p.Set("bogus:staging") == nil {
+		t.Fatal("expected invalid port error")
+	}
+}
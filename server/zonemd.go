@@ -0,0 +1,251 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// miekg/dns predates ZONEMD (RFC 8976), so ZONEMD records are handled in the
+// generic RFC 3597 form.
+const (
+	typeZONEMD uint16 = 63
+
+	zonemdSchemeSimple = 1
+	zonemdHashSHA384   = 1
+)
+
+// zonemdValue converts ZONEMD presentation format data ("serial scheme
+// hash-algorithm digest") to the RFC 3597 generic form.
+func zonemdValue(v string) (string, error) {
+	fields := strings.Fields(v)
+	if len(fields) < 4 {
+		return "", fmt.Errorf("expected serial, scheme, hash algorithm and digest; actual: %q", v)
+	}
+
+	serial, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid serial %q", fields[0])
+	}
+	var hdr [6]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(serial))
+	for i, f := range fields[1:3] {
+		n, err := strconv.ParseUint(f, 10, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid scheme or hash algorithm %q", f)
+		}
+		hdr[4+i] = byte(n)
+	}
+
+	digest, err := hex.DecodeString(strings.Join(fields[3:], ""))
+	if err != nil || len(digest) < 12 {
+		return "", fmt.Errorf("invalid digest %q", strings.Join(fields[3:], ""))
+	}
+
+	rdata := append(hdr[:], digest...)
+
+	return fmt.Sprintf(`\# %d %x`, len(rdata), rdata), nil
+}
+
+// addZoneDigests adds a ZONEMD record to the apex of every zone in d with an
+// SOA record, if -zonemd is set, replacing any apex ZONEMD records read from
+// the data file.
+func addZoneDigests(d data) {
+	if !zonemd {
+		return
+	}
+
+	zones := make(map[string]*dns.SOA)
+	for domain, recs := range d {
+		for _, set := range recs.sets() {
+			for _, rr := range set.data[dns.TypeSOA] {
+				if strings.EqualFold(rr.Header().Name, domain) {
+					zones[strings.ToLower(domain)] = rr.(*dns.SOA)
+				}
+			}
+		}
+	}
+
+	for zone, recs := range d {
+		soa, ok := zones[strings.ToLower(zone)]
+		if !ok {
+			if !inZones(zones, zone) {
+				log.Printf("Warning: no ZONEMD record for %s without an apex SOA record", zone)
+			}
+			continue
+		}
+
+		digest, err := zoneDigest(d, zone)
+		if err != nil {
+			log.Printf("Warning: no ZONEMD record for %s: %s", zone, err)
+			continue
+		}
+
+		rdata := make([]byte, 6, 6+len(digest))
+		binary.BigEndian.PutUint32(rdata, soa.Serial)
+		rdata[4], rdata[5] = zonemdSchemeSimple, zonemdHashSHA384
+		rdata = append(rdata, digest...)
+
+		rrs := []dns.RR{&dns.RFC3597{
+			Hdr: dns.RR_Header{
+				Name:   soa.Hdr.Name,
+				Rrtype: typeZONEMD,
+				Class:  dns.ClassINET,
+				Ttl:    soa.Hdr.Ttl,
+			},
+			Rdata: hex.EncodeToString(rdata),
+		}}
+		for _, rr := range recs.data[typeZONEMD] {
+			if !strings.EqualFold(rr.Header().Name, zone) {
+				rrs = append(rrs, rr)
+			}
+		}
+		recs.data[typeZONEMD] = rrs
+	}
+}
+
+// inZones reports whether name is below the apex of any of zones.
+func inZones(zones map[string]*dns.SOA, name string) bool {
+	name = strings.ToLower(name)
+	for off, end := dns.NextLabel(name, 0); !end; off, end = dns.NextLabel(name, off) {
+		if _, ok := zones[name[off:]]; ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// zoneDigest returns the SHA-384 digest of the zone at apex under the ZONEMD
+// simple scheme (RFC 8976 3.3): every record in d at or below the apex but the
+// apex ZONEMD records, in canonical form and order, without duplicates.
+// Below a delegation, only its NS and DS records and glue belong to the zone.
+func zoneDigest(d data, apex string) ([]byte, error) {
+	type entry struct {
+		owner string
+		typ   uint16
+		wire  []byte
+		rdata []byte
+	}
+
+	apex = strings.ToLower(apex)
+	var rrs []dns.RR
+	cuts := make(map[string]bool)
+	for _, recs := range d {
+		for _, set := range recs.sets() {
+			for typ, typed := range set.data {
+				for _, rr := range typed {
+					owner := strings.ToLower(rr.Header().Name)
+					if !dns.IsSubDomain(apex, owner) {
+						continue
+					}
+					if typ == dns.TypeNS && owner != apex {
+						cuts[owner] = true
+					}
+					rrs = append(rrs, rr)
+				}
+			}
+		}
+	}
+
+	var entries []entry
+	buf := make([]byte, dns.MaxMsgSize)
+	for _, rr := range rrs {
+		owner := strings.ToLower(rr.Header().Name)
+		typ := rr.Header().Rrtype
+		if typ == typeZONEMD && owner == apex || !inZone(cuts, apex, owner, typ) {
+			continue
+		}
+
+		c := canonicalRR(rr)
+		off, err := dns.PackRR(c, buf, 0, nil, false)
+		if err != nil {
+			return nil, err
+		}
+		wire := append([]byte(nil), buf[:off]...)
+		rdata := wire[len(wire)-int(c.Header().Rdlength):]
+		entries = append(entries, entry{owner, typ, wire, rdata})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case a.owner != b.owner:
+			return canonicalLess(a.owner, b.owner)
+		case a.typ != b.typ:
+			return a.typ < b.typ
+		}
+		return bytes.Compare(a.rdata, b.rdata) < 0
+	})
+
+	h := sha512.New384()
+	for i, e := range entries {
+		if i > 0 && bytes.Equal(e.wire, entries[i-1].wire) {
+			continue
+		}
+		h.Write(e.wire)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// inZone reports whether a record of type typ owned by owner belongs to the
+// zone at apex, given the zone's delegation points cuts: at a cut only NS, DS
+// and glue records do, and below one only glue.
+func inZone(cuts map[string]bool, apex, owner string, typ uint16) bool {
+	glue := typ == dns.TypeA || typ == dns.TypeAAAA
+	if cuts[owner] {
+		return glue || typ == dns.TypeNS || typ == dns.TypeDS
+	}
+	for off, end := dns.NextLabel(owner, 0); !end && owner[off:] != apex; off, end = dns.NextLabel(owner, off) {
+		if cuts[owner[off:]] {
+			return glue
+		}
+	}
+
+	return true
+}
+
+// canonicalRR returns a copy of rr in canonical form (RFC 4034 6.2), with its
+// owner name and any domain names in its RDATA lowercased.
+func canonicalRR(rr dns.RR) dns.RR {
+	c := dns.Copy(rr)
+	c.Header().Name = strings.ToLower(c.Header().Name)
+
+	switch v := c.(type) {
+	case *dns.NS:
+		v.Ns = strings.ToLower(v.Ns)
+	case *dns.CNAME:
+		v.Target = strings.ToLower(v.Target)
+	case *dns.PTR:
+		v.Ptr = strings.ToLower(v.Ptr)
+	case *dns.MX:
+		v.Mx = strings.ToLower(v.Mx)
+	case *dns.SOA:
+		v.Ns = strings.ToLower(v.Ns)
+		v.Mbox = strings.ToLower(v.Mbox)
+	}
+
+	return c
+}
+
+// canonicalLess reports whether the lowercased name a sorts before b in
+// canonical DNS name order (RFC 4034 6.1), comparing labels from the root.
+func canonicalLess(a, b string) bool {
+	la, lb := dns.SplitDomainName(a), dns.SplitDomainName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if la[i] != lb[j] {
+			return la[i] < lb[j]
+		}
+	}
+
+	return len(la) < len(lb)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestZONEMD(t *testing.T) {
+	defer func(v bool) { zonemd = v }(zonemd)
+	zonemd = true
+
+	// RFC 8976 A.1, whose apex ZONEMD record is replaced by the computed one.
+	d := loadTestData(t, `{"example.": {
+		"soa": [{"ttl": "86400", "value": "ns1.example. admin.example. 2018031900 1800 900 604800 86400"}],
+		"ns": [{"ttl": "86400", "value": "ns1.example."}, {"ttl": "86400", "value": "ns2.example."}],
+		"zonemd": [{"ttl": "86400", "value": "2018031900 1 1 000000000000000000000000"}],
+		"a": [{"hostname": "ns1", "ttl": "3600", "value": "203.0.113.63"}],
+		"aaaa": [{"hostname": "NS2", "ttl": "3600", "value": "2001:db8::63"}]
+	}}`)
+	NewServer(d)
+
+	m := query(handler(d["example."]), "example.", typeZONEMD)
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected 1 ZONEMD answer; actual: %v", m.Answer)
+	}
+	rr, ok := m.Answer[0].(*dns.RFC3597)
+	if !ok {
+		t.Fatalf("expected ZONEMD record; actual: %v", m.Answer[0])
+	}
+
+	const expected = "7848b91c" + "01" + "01" +
+		"c68090d90a7aed716bc459f9340e3d7c1370d4d24b7e2fc3" +
+		"a1ddc0b9a87153b9a9713b3c9ae5cc27777f98b8e730044c"
+	if rr.Rdata != expected {
+		t.Errorf("expected RDATA %s; actual: %s", expected, rr.Rdata)
+	}
+	if rr.Hdr.Ttl != 86400 {
+		t.Errorf("expected the SOA TTL 86400; actual: %d", rr.Hdr.Ttl)
+	}
+
+	// The same zone split across domains, plus a delegation whose occluded
+	// records don't belong to it, digests the same apart from the delegation.
+	split := loadTestData(t, `{
+		"example.": {
+			"soa": [{"ttl": "86400", "value": "ns1.example. admin.example. 2018031900 1800 900 604800 86400"}],
+			"ns": [{"ttl": "86400", "value": "ns1.example."}, {"ttl": "86400", "value": "ns2.example."}]
+		},
+		"ns1.example.": {"a": [{"ttl": "3600", "value": "203.0.113.63"}]},
+		"ns2.example.": {"aaaa": [{"ttl": "3600", "value": "2001:db8::63"}]}
+	}`)
+	digest, err := zoneDigest(split, "example.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := fmt.Sprintf("%x", digest); actual != expected[12:] {
+		t.Errorf("expected digest %s; actual: %s", expected[12:], actual)
+	}
+
+	delegated := func(occluded string) string {
+		d := loadTestData(t, `{
+			"example.": {"soa": [{"value": "ns1.example. admin.example. 1 1800 900 604800 86400"}]},
+			"sub.example.": {
+				"ns": [{"value": "ns.sub.example."}],
+				"txt": [{"value": "occluded"}]
+			},
+			"ns.sub.example.": {"a": [{"value": "192.0.2.53"}]}`+occluded+`
+		}`)
+		digest, err := zoneDigest(d, "example.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprintf("%x", digest)
+	}
+	if a, b := delegated(""), delegated(`, "www.sub.example.": {"a": [{"value": "192.0.2.80"}]}`); a == b {
+		t.Error("expected glue below a delegation in the digest")
+	}
+	if a, b := delegated(""), delegated(`, "www.sub.example.": {"txt": [{"value": "occluded"}]}`); a != b {
+		t.Error("expected occluded records left out of the digest")
+	}
+
+	for _, bad := range []string{
+		`{"value": "2018031900 1 1"}`,
+		`{"value": "2018031900 1 1 zz"}`,
+		`{"value": "serial 1 1 000000000000000000000000"}`,
+	} {
+		err := json.Unmarshal([]byte(`{"example.": {"zonemd": [`+bad+`]}}`), &d)
+		if err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}